@@ -0,0 +1,103 @@
+package joker
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerWindow   = 5 * time.Minute
+	defaultBreakerCooldown = time.Minute
+)
+
+// breaker is a circuit breaker over requests to one Joker API. After
+// threshold consecutive failures within window it opens, failing requests
+// at once with ErrCircuitOpen. Once cooldown has passed it lets a single
+// probe through: success closes it, failure opens it for another cooldown.
+type breaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	clock     clock
+
+	mu        sync.Mutex
+	failures  int
+	first     time.Time // of the current run of failures
+	openUntil time.Time // zero while closed
+	probing   bool
+}
+
+func (c *Client) newBreaker() *breaker {
+	if c.BreakerThreshold <= 0 {
+		return nil
+	}
+	b := &breaker{
+		threshold: c.BreakerThreshold,
+		window:    defaultBreakerWindow,
+		cooldown:  defaultBreakerCooldown,
+		clock:     c.clk(),
+	}
+	if c.BreakerWindow > 0 {
+		b.window = time.Duration(c.BreakerWindow)
+	}
+	if c.BreakerCooldown > 0 {
+		b.cooldown = time.Duration(c.BreakerCooldown)
+	}
+	return b
+}
+
+// allow returns ErrCircuitOpen if a request must not be sent now.
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.openUntil.IsZero():
+		return nil
+	case b.clock.Now().Before(b.openUntil) || b.probing:
+		return ErrCircuitOpen
+	default:
+		b.probing = true
+		return nil
+	}
+}
+
+// record notes the outcome of a request that allow let through. Network
+// errors and 5xx responses are failures; anything else Joker answered
+// shows it is up. A request ended by the caller's cancellation says
+// nothing either way.
+func (b *breaker) record(ctx context.Context, res *httpResult, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil && ctx.Err() != nil {
+		b.probing = false
+		return
+	}
+	if err == nil && res.status < http.StatusInternalServerError {
+		b.failures, b.openUntil, b.probing = 0, time.Time{}, false
+		return
+	}
+
+	now := b.clock.Now()
+	if b.probing {
+		b.probing = false
+		b.openUntil = now.Add(b.cooldown)
+		return
+	}
+	if b.failures == 0 || now.Sub(b.first) > b.window {
+		b.failures, b.first = 0, now
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
@@ -0,0 +1,126 @@
+package joker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libdns/libdns"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	var up atomic.Bool
+	var calls atomic.Int32
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !up.Load() {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, "OK")
+	}
+	clk := newFakeClock()
+	c := newTestClient(t, f, func(c *Client) {
+		c.BreakerThreshold = 2
+		c.BreakerCooldown = Duration(time.Minute)
+		c.clock = clk
+	})
+	appendTXT := func() error {
+		_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+			libdns.TXT{Name: "_acme-challenge", Text: "token"},
+		})
+		return err
+	}
+
+	// Two failures in a row open the breaker
+	for range 2 {
+		var apiErr *APIError
+		require.ErrorAs(t, appendTXT(), &apiErr)
+	}
+	require.EqualValues(t, 2, calls.Load())
+
+	// While open, requests fail at once without reaching Joker
+	up.Store(true)
+	assert.ErrorIs(t, appendTXT(), ErrCircuitOpen)
+	assert.EqualValues(t, 2, calls.Load())
+
+	// After the cooldown a probe goes through, and its success closes it
+	clk.advance(time.Minute)
+	require.NoError(t, appendTXT())
+	require.NoError(t, appendTXT())
+	assert.EqualValues(t, 4, calls.Load())
+}
+
+func TestBreaker(t *testing.T) {
+	failed := &httpResult{status: http.StatusServiceUnavailable}
+	ok := &httpResult{status: http.StatusOK}
+	ctx := context.Background()
+
+	t.Run("failed probe reopens", func(t *testing.T) {
+		clk := newFakeClock()
+		b := &breaker{threshold: 1, window: time.Minute, cooldown: time.Minute, clock: clk}
+
+		require.NoError(t, b.allow())
+		b.record(ctx, failed, nil)
+		assert.ErrorIs(t, b.allow(), ErrCircuitOpen)
+
+		clk.advance(time.Minute)
+		require.NoError(t, b.allow())
+		// Only one probe at a time
+		assert.ErrorIs(t, b.allow(), ErrCircuitOpen)
+		b.record(ctx, nil, errors.New("connection refused"))
+		assert.ErrorIs(t, b.allow(), ErrCircuitOpen)
+
+		clk.advance(time.Minute)
+		require.NoError(t, b.allow())
+		b.record(ctx, ok, nil)
+		assert.NoError(t, b.allow())
+	})
+
+	t.Run("failures outside the window", func(t *testing.T) {
+		clk := newFakeClock()
+		b := &breaker{threshold: 2, window: time.Minute, cooldown: time.Minute, clock: clk}
+
+		b.record(ctx, failed, nil)
+		clk.advance(2 * time.Minute)
+		b.record(ctx, failed, nil)
+		assert.NoError(t, b.allow())
+
+		b.record(ctx, failed, nil)
+		assert.ErrorIs(t, b.allow(), ErrCircuitOpen)
+	})
+
+	t.Run("success resets the count", func(t *testing.T) {
+		b := &breaker{threshold: 2, window: time.Minute, cooldown: time.Minute, clock: newFakeClock()}
+
+		b.record(ctx, failed, nil)
+		b.record(ctx, &httpResult{status: http.StatusBadRequest}, nil)
+		b.record(ctx, failed, nil)
+		assert.NoError(t, b.allow())
+	})
+
+	t.Run("cancellation is not a failure", func(t *testing.T) {
+		b := &breaker{threshold: 1, window: time.Minute, cooldown: time.Minute, clock: newFakeClock()}
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		b.record(cctx, nil, cctx.Err())
+		assert.NoError(t, b.allow())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		b := (&Client{}).newBreaker()
+		assert.Nil(t, b)
+		b.record(ctx, failed, nil)
+		assert.NoError(t, b.allow())
+	})
+}
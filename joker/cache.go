@@ -0,0 +1,72 @@
+package joker
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// zoneCache keeps GetRecords results for CacheTTL; a nil cache (the
+// default) keeps nothing. Every write to a zone drops its entry and bumps
+// its generation, so a read that was in flight during the write doesn't
+// store what it fetched.
+type zoneCache struct {
+	mu      sync.Mutex
+	entries map[string]zoneCacheEntry
+	gens    map[string]uint64
+}
+
+type zoneCacheEntry struct {
+	records []libdns.Record
+	expires time.Time
+}
+
+func newZoneCache() *zoneCache {
+	return &zoneCache{
+		entries: make(map[string]zoneCacheEntry),
+		gens:    make(map[string]uint64),
+	}
+}
+
+// get returns zone's cached records if they are still fresh, and the
+// generation to pass to put after fetching them (again).
+func (c *zoneCache) get(zone string, now time.Time) ([]libdns.Record, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[zone]
+	if !ok || !now.Before(e.expires) {
+		return nil, c.gens[zone], false
+	}
+	return slices.Clone(e.records), c.gens[zone], true
+}
+
+// put caches records for zone unless it was written since gen.
+func (c *zoneCache) put(zone string, gen uint64, records []libdns.Record, expires time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gens[zone] != gen {
+		return
+	}
+	c.entries[zone] = zoneCacheEntry{records: slices.Clone(records), expires: expires}
+}
+
+func (c *zoneCache) invalidate(zone string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, zone)
+	c.gens[zone]++
+}
@@ -0,0 +1,165 @@
+package joker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libdns/libdns"
+)
+
+func TestCache(t *testing.T) {
+	for _, mode := range []string{modeDynDNS, modeDMAPI} {
+		t.Run(mode, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com", "www A 0 192.0.2.1 300")
+			clk := newFakeClock()
+			c := newTestClient(t, f, func(c *Client) {
+				c.Mode = mode
+				c.CacheTTL = Duration(time.Minute)
+				c.clock = clk
+			})
+			gets := func() int { return len(f.received(dmapiPath + "/dns-zone-get")) }
+
+			for range 3 {
+				records, err := c.GetRecords(context.Background(), "example.com")
+				require.NoError(t, err)
+				assert.Len(t, records, 1)
+			}
+			assert.Equal(t, 1, gets())
+
+			// A write drops the zone's entry
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			require.NoError(t, err)
+			before := gets()
+			records, err := c.GetRecords(context.Background(), "example.com")
+			require.NoError(t, err)
+			assert.Len(t, records, 2)
+			assert.Equal(t, before+1, gets())
+
+			// As does time
+			clk.advance(time.Minute)
+			_, err = c.GetRecords(context.Background(), "example.com")
+			require.NoError(t, err)
+			assert.Equal(t, before+2, gets())
+		})
+	}
+}
+
+func TestCacheBypassedForWrites(t *testing.T) {
+	for _, mode := range []string{modeDynDNS, modeDMAPI} {
+		t.Run(mode, func(t *testing.T) {
+			f := newFakeJoker(t)
+			c := newTestClient(t, f, func(c *Client) {
+				c.Mode = mode
+				c.CacheTTL = Duration(time.Minute)
+				c.clock = newFakeClock()
+			})
+			ctx := context.Background()
+
+			// Caches the zone, then changes it behind the cache's back
+			changed := func(values ...string) {
+				t.Helper()
+				f.setZone("example.com", `_acme-challenge TXT 0 "one" 300`)
+				_, err := c.GetRecords(ctx, "example.com")
+				require.NoError(t, err)
+
+				var lines []string
+				for _, v := range values {
+					lines = append(lines, `_acme-challenge TXT 0 "`+v+`" 300`)
+				}
+				f.setZone("example.com", lines...)
+			}
+			values := func() []string {
+				t.Helper()
+				var got []string
+				for _, line := range f.zone("example.com") {
+					got = append(got, line[strings.Index(line, `"`)+1:strings.LastIndex(line, `"`)])
+				}
+				return got
+			}
+
+			changed("one", "two")
+			_, err := c.AppendRecords(ctx, "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "three"},
+			})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"one", "two", "three"}, values(), "append")
+
+			changed("one", "two")
+			_, err = c.DeleteRecords(ctx, "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "one"},
+			})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"two"}, values(), "delete")
+
+			changed("two")
+			_, err = c.SetRecords(ctx, "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", TTL: 300 * time.Second, Text: "one"},
+			})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"one"}, values(), "set")
+		})
+	}
+}
+
+func TestCacheSkipUnchanged(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com", `_acme-challenge TXT 0 "one" 300`)
+	c := newTestClient(t, f, func(c *Client) {
+		c.CacheTTL = Duration(time.Minute)
+		c.SkipUnchanged = true
+		c.clock = newFakeClock()
+	})
+	ctx := context.Background()
+
+	_, err := c.GetRecords(ctx, "example.com")
+	require.NoError(t, err)
+
+	// The cached zone already holds the value, so nothing is read or written
+	_, err = c.AppendRecords(ctx, "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "one"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, f.received(dmapiPath+"/dns-zone-get"), 1)
+	assert.Empty(t, f.received(nicReplacePath))
+}
+
+func TestCacheDisabled(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com", "www A 0 192.0.2.1 300")
+	c := newTestClient(t, f)
+
+	for range 3 {
+		_, err := c.GetRecords(context.Background(), "example.com")
+		require.NoError(t, err)
+	}
+	assert.Len(t, f.received(dmapiPath+"/dns-zone-get"), 3)
+}
+
+func TestZoneCacheWriteDuringRead(t *testing.T) {
+	c := newZoneCache()
+	now := time.Now()
+	records := []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "token"}}
+
+	// A read starts, a write lands, then the read finishes
+	_, gen, ok := c.get("example.com", now)
+	require.False(t, ok)
+	c.invalidate("example.com")
+	c.put("example.com", gen, records, now.Add(time.Minute))
+
+	_, _, ok = c.get("example.com", now)
+	assert.False(t, ok, "stale read was cached")
+
+	_, gen, _ = c.get("example.com", now)
+	c.put("example.com", gen, records, now.Add(time.Minute))
+	got, _, ok := c.get("example.com", now)
+	assert.True(t, ok)
+	assert.Equal(t, records, got)
+}
@@ -0,0 +1,2354 @@
+package joker
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/idna"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+
+	"github.com/libdns/libdns"
+)
+
+type rrsetKey struct {
+	zone  string
+	label string
+	rtype string
+}
+
+const (
+	modulePath = "github.com/samliddicott/caddy-dns-joker"
+
+	defaultEndpoint      = "https://svc.joker.com" + nicReplacePath
+	defaultDMAPIEndpoint = "https://dmapi.joker.com" + dmapiPath
+	defaultTimeout       = 30 * time.Second
+	defaultConcurrency   = 4
+	defaultTTL           = time.Hour
+	defaultMinTTL        = 60 * time.Second
+	defaultMaxTTL        = 86400 * time.Second
+
+	// Paths of the two APIs below BaseURL
+	nicReplacePath = "/nic/replace"
+	dmapiPath      = "/request"
+
+	modeDynDNS = "dyndns"
+	modeDMAPI  = "dmapi"
+
+	contentTypeForm = "form"
+	contentTypeJSON = "json"
+)
+
+// Client implements libdns interfaces for Joker DNS. Make one with New or
+// NewWithAPIToken, or fill in its fields and call Setup.
+type Client struct {
+	// Authentication (exactly one method required)
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	APIToken string `json:"api_token,omitempty"`
+
+	// Files holding the username and password, read by Setup
+	// instead of setting Username and Password. Trailing whitespace is
+	// trimmed.
+	UsernameFile string `json:"username_file,omitempty"`
+	PasswordFile string `json:"password_file,omitempty"`
+
+	// Directory of mounted secrets (e.g. /run/secrets) holding files
+	// named "username", "password" and "api_token". Each credential must
+	// be configured in one place only: a file that exists here is an
+	// error if the same credential is also set inline or by a *_file.
+	SecretsDir string `json:"secrets_dir,omitempty"`
+
+	// Credentials for particular zones, overriding the ones above, e.g.
+	// for domains in another Joker account
+	ZoneCredentials map[string]Credential `json:"zone_credentials,omitempty"`
+
+	// Alias of APIToken matching Joker's "API key" naming
+	APIKey string `json:"api_key,omitempty"`
+
+	// Backend used for writes: "dyndns" (/nic/replace, default) or
+	// "dmapi" (full zone management). Reads always use DMAPI.
+	Mode string `json:"mode,omitempty"`
+
+	// Optional overrides
+	Endpoint      string `json:"endpoint,omitempty"`
+	DMAPIEndpoint string `json:"dmapi_endpoint,omitempty"`
+
+	// Common base for both APIs, e.g. a reverse proxy mounting Joker
+	// under a path: Endpoint defaults to BaseURL + "/nic/replace" and
+	// DMAPIEndpoint to BaseURL + "/request". Explicit endpoints win.
+	BaseURL string `json:"base_url,omitempty"`
+
+	// HTTP statuses from /nic/replace treated as success (default 200),
+	// for proxies in front of Joker that answer e.g. 204
+	SuccessStatuses []int `json:"success_statuses,omitempty"`
+
+	// /nic/replace endpoints tried in order, after Endpoint if that is
+	// also set, when one fails with a network error or 5xx
+	Endpoints []string `json:"endpoints,omitempty"`
+
+	// HTTP client timeout (default 30s)
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// Budget for writing one RRset, including retries and the DMAPI
+	// read-modify-write (default none; the caller's context applies)
+	PerRequestTimeout Duration `json:"per_request_timeout,omitempty"`
+
+	// Connection pooling: idle connections kept (default 100), how long
+	// they are kept (default 90s), or no reuse at all
+	MaxIdleConns      int      `json:"max_idle_conns,omitempty"`
+	IdleConnTimeout   Duration `json:"idle_conn_timeout,omitempty"`
+	DisableKeepAlives bool     `json:"disable_keep_alives,omitempty"`
+
+	// Outbound HTTP(S) proxy URL; when empty HTTP_PROXY/HTTPS_PROXY/
+	// NO_PROXY from the environment apply
+	Proxy string `json:"proxy,omitempty"`
+
+	// PEM file of CA certificates trusted for the endpoints, replacing
+	// the system roots (for gateways with a private CA)
+	CAFile string `json:"ca_file,omitempty"`
+
+	// Disable TLS certificate verification. For testing only.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+
+	// Host header and TLS server name (SNI, and the name the certificate
+	// is verified against) for /nic/replace requests, when the endpoints
+	// point at e.g. an internal load balancer's address instead of
+	// Joker's name. DMAPI and IP detection requests are unaffected.
+	HostHeader    string `json:"host_header,omitempty"`
+	TLSServerName string `json:"tls_server_name,omitempty"`
+
+	// Retries after network errors and 5xx responses (default 3,
+	// negative disables)
+	MaxRetries int `json:"max_retries,omitempty"`
+
+	// Maximum requests per second to Joker (default unlimited)
+	RateLimit float64 `json:"rate_limit,omitempty"`
+
+	// Circuit breaker: after BreakerThreshold consecutive network errors
+	// or 5xx responses within BreakerWindow (default 5m), requests fail at
+	// once with ErrCircuitOpen for BreakerCooldown (default 1m), then one
+	// probe decides whether to resume. DMAPI and /nic/replace each have
+	// their own, so an outage of one doesn't stop requests to the other.
+	// Disabled unless the threshold is set.
+	BreakerThreshold int      `json:"breaker_threshold,omitempty"`
+	BreakerWindow    Duration `json:"breaker_window,omitempty"`
+	BreakerCooldown  Duration `json:"breaker_cooldown,omitempty"`
+
+	// How long GetRecords results are reused (default 0, never). Writes
+	// through this Client clear their zone's entry; changes made
+	// elsewhere may go unseen for up to this long, though writes always
+	// read the records they merge with or delete from afresh.
+	CacheTTL Duration `json:"cache_ttl,omitempty"`
+
+	// Maximum RRsets written in parallel by AppendRecords (default 4)
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Attempt every RRset even after failures, returning the successful
+	// records and all failures joined (errors.Join)
+	ContinueOnError bool `json:"continue_on_error,omitempty"`
+
+	// Delete RRsets already written when AppendRecords fails part way
+	// (best-effort)
+	RollbackOnError bool `json:"rollback_on_error,omitempty"`
+
+	// TTL for records that don't specify one (default 1h). Joker accepts
+	// 60s to 86400s; values outside are clamped.
+	DefaultTTL Duration `json:"default_ttl,omitempty"`
+
+	// TTL for every record written, overriding the records' own TTLs
+	// (DefaultTTL only fills in missing ones)
+	ForceTTL Duration `json:"force_ttl,omitempty"`
+
+	// TTL bounds; TTLs outside are clamped with a warning (default 60s
+	// and 86400s, Joker's documented range)
+	MinTTL Duration `json:"min_ttl,omitempty"`
+	MaxTTL Duration `json:"max_ttl,omitempty"`
+
+	// Poll the zone's authoritative nameservers after writing A, AAAA
+	// and TXT records until the new values are visible, for up to
+	// PropagationTimeout (default 2m)
+	WaitForPropagation bool     `json:"wait_for_propagation,omitempty"`
+	PropagationTimeout Duration `json:"propagation_timeout,omitempty"`
+
+	// Nameservers (host or host:port) queried for propagation checks
+	// instead of the zone's authoritative ones, e.g. for split-horizon DNS
+	Resolvers []string `json:"resolvers,omitempty"`
+
+	// Don't write an RRset in AppendRecords when it already holds every
+	// value being added, keeping its TTL
+	SkipUnchanged bool `json:"skip_unchanged,omitempty"`
+
+	// Return ErrNoChange, alongside the records, from AppendRecords and
+	// SetRecords when Joker reported "nochg" for every RRset written
+	ReportNoChange bool `json:"report_no_change,omitempty"`
+
+	// Send TXT values byte for byte, without stripping surrounding quotes
+	// or splitting long values into quoted strings
+	RawTXT bool `json:"raw_txt,omitempty"`
+
+	// Validate credentials and log intended changes without writing
+	// anything
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// HTTP method for /nic/replace: "POST" (default, form body) or "GET"
+	// (parameters in the query string)
+	HTTPMethod string `json:"http_method,omitempty"`
+
+	// Renames /nic/replace form fields (zone, label, type, ttl, value,
+	// username, password, api_token) for Joker-compatible endpoints that
+	// expect other names, e.g. {"value": "myip"}
+	FieldMap map[string]string `json:"field_map,omitempty"`
+
+	// Body encoding of /nic/replace requests: "form" (default,
+	// urlencoded) or "json" (a JSON object of the fields, POST only).
+	// DMAPI requests are always forms.
+	ContentType string `json:"content_type,omitempty"`
+
+	// Log every request form and response body, credentials redacted, at
+	// debug level
+	DebugHTTP bool `json:"debug_http,omitempty"`
+
+	// Send each operation's request ID, which its log entries carry as
+	// request_id, to Joker as an X-Request-ID header
+	SendRequestID bool `json:"send_request_id,omitempty"`
+
+	// IP echo services used by UpdateDynamicIP and DetectPublicIP
+	// (default https://ipv4.icanhazip.com and https://ipv6.icanhazip.com)
+	IPDetectURL   string `json:"ip_detect_url,omitempty"`
+	IPv6DetectURL string `json:"ipv6_detect_url,omitempty"`
+
+	// User-Agent header override
+	UserAgent string `json:"user_agent,omitempty"`
+
+	client *http.Client
+	// replaceClient sends /nic/replace requests; see setupReplaceClient
+	replaceClient *http.Client
+	limiter       *rate.Limiter
+	// breakers for DMAPI and /nic/replace requests
+	dmapiBreaker   *breaker
+	replaceBreaker *breaker
+	cache          *zoneCache
+	session        *dmapiSession
+	// zoneAuths holds ZoneCredentials by normalized zone
+	zoneAuths map[string]*zoneAuth
+	rrLocks   *rrsetLocks
+	jitter    func(time.Duration) time.Duration
+	clock     clock
+	trace     *Trace
+
+	// nicWarning limits the warning about non-A/AAAA/TXT records sent
+	// via /nic/replace to one per Client
+	nicWarning *sync.Once
+	logger     *zap.Logger
+}
+
+var (
+	_ libdns.RecordGetter   = (*Client)(nil)
+	_ libdns.RecordAppender = (*Client)(nil)
+	_ libdns.RecordSetter   = (*Client)(nil)
+	_ libdns.RecordDeleter  = (*Client)(nil)
+	_ libdns.ZoneLister     = (*Client)(nil)
+)
+
+// Setup prepares a Client configured through its fields rather than made
+// with New: it applies opts, reads the credential files and fills in
+// defaults. Call it once, before any other method.
+func (c *Client) Setup(opts ...Option) error {
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := c.loadCredentialFiles(); err != nil {
+		return err
+	}
+	return c.setup()
+}
+
+// loadCredentialFiles reads the credentials found in SecretsDir, then
+// UsernameFile and PasswordFile. A credential may come from only one
+// place: inline, its own file or SecretsDir.
+func (c *Client) loadCredentialFiles() error {
+	if err := c.checkCredentialSources(); err != nil {
+		return err
+	}
+
+	if c.SecretsDir != "" {
+		for _, f := range []struct {
+			name      string
+			dst       *string
+			conflicts string // the field(s) already giving this credential
+		}{
+			{"username", &c.Username, setFields("username", c.Username, "username_file", c.UsernameFile)},
+			{"password", &c.Password, setFields("password", c.Password, "password_file", c.PasswordFile)},
+			{"api_token", &c.APIToken, setFields("api_token", c.APIToken, "api_key", c.APIKey)},
+		} {
+			v, err := readCredentialFile(filepath.Join(c.SecretsDir, f.name))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("reading secrets_dir: %w", err)
+			}
+			if f.conflicts != "" {
+				return fmt.Errorf("secrets_dir contains %s, which is also set by %s; configure it in one place",
+					f.name, f.conflicts)
+			}
+			*f.dst = v
+		}
+	}
+
+	if c.UsernameFile != "" {
+		v, err := readCredentialFile(c.UsernameFile)
+		if err != nil {
+			return fmt.Errorf("reading username_file: %w", err)
+		}
+		c.Username = v
+	}
+	if c.PasswordFile != "" {
+		v, err := readCredentialFile(c.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("reading password_file: %w", err)
+		}
+		c.Password = v
+	}
+	return nil
+}
+
+// checkCredentialSources rejects credentials given twice, or by two
+// authentication methods, before any file is read.
+func (c *Client) checkCredentialSources() error {
+	switch {
+	case c.Username != "" && c.UsernameFile != "":
+		return fmt.Errorf("username and username_file are both set; configure only one")
+	case c.Password != "" && c.PasswordFile != "":
+		return fmt.Errorf("password and password_file are both set; configure only one")
+	}
+
+	token := setFields("api_token", c.APIToken, "api_key", c.APIKey)
+	userPass := setFields("username_file", c.UsernameFile, "password_file", c.PasswordFile)
+	if token != "" && userPass != "" {
+		return fmt.Errorf("%s and %s select different authentication methods; configure only one",
+			token, userPass)
+	}
+	return nil
+}
+
+// setFields names whichever of the two fields are set, joined
+// with "and", or returns "" if neither is.
+func setFields(name1, v1, name2, v2 string) string {
+	switch {
+	case v1 != "" && v2 != "":
+		return name1 + " and " + name2
+	case v1 != "":
+		return name1
+	case v2 != "":
+		return name2
+	}
+	return ""
+}
+
+func readCredentialFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimRightFunc(string(b), unicode.IsSpace)
+	if v == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return v, nil
+}
+
+// setup applies defaults and builds the runtime state shared by Setup
+// and New. A client or logger that is already set is kept.
+func (c *Client) setup() error {
+	if c.logger == nil {
+		c.logger = zap.NewNop()
+	}
+	if c.client == nil {
+		client, err := c.newHTTPClient()
+		if err != nil {
+			return err
+		}
+		c.client = client
+	}
+	c.setupReplaceClient()
+	c.session = new(dmapiSession)
+	if err := c.setupZoneAuths(); err != nil {
+		return err
+	}
+	c.rrLocks = &rrsetLocks{locks: make(map[rrsetKey]*sync.Mutex)}
+	c.nicWarning = new(sync.Once)
+	if c.jitter == nil {
+		c.jitter = newJitter(rand.Uint64())
+	}
+	if c.RateLimit > 0 {
+		c.limiter = rate.NewLimiter(rate.Limit(c.RateLimit), 1)
+	}
+	c.dmapiBreaker, c.replaceBreaker = c.newBreaker(), c.newBreaker()
+	if c.CacheTTL > 0 {
+		c.cache = newZoneCache()
+	}
+
+	c.Endpoint = strings.TrimRight(c.Endpoint, "/")
+	for i, ep := range c.Endpoints {
+		c.Endpoints[i] = strings.TrimRight(ep, "/")
+	}
+	c.DMAPIEndpoint = strings.TrimRight(c.DMAPIEndpoint, "/")
+	if base := strings.TrimRight(c.BaseURL, "/"); base != "" {
+		if c.Endpoint == "" && len(c.Endpoints) == 0 {
+			c.Endpoint = base + nicReplacePath
+		}
+		if c.DMAPIEndpoint == "" {
+			c.DMAPIEndpoint = base + dmapiPath
+		}
+	}
+	if c.Endpoint == "" && len(c.Endpoints) == 0 {
+		c.Endpoint = defaultEndpoint
+	}
+	if c.DMAPIEndpoint == "" {
+		c.DMAPIEndpoint = defaultDMAPIEndpoint
+	}
+	if c.UserAgent == "" {
+		c.UserAgent = defaultUserAgent()
+	}
+	if c.Mode == "" {
+		c.Mode = modeDynDNS
+	}
+	if c.APIToken == "" {
+		c.APIToken = c.APIKey
+	}
+
+	return nil
+}
+
+// Validate checks the configuration. Caddy calls it after expanding
+// placeholders, so a credential referencing an unset environment variable
+// is caught.
+func (c *Client) Validate() error {
+	switch c.Mode {
+	case "", modeDynDNS, modeDMAPI:
+	default:
+		return fmt.Errorf("unknown mode %q: must be %q or %q", c.Mode, modeDynDNS, modeDMAPI)
+	}
+
+	// Checked first, as the endpoints may have been derived from it
+	if err := validateEndpoint("base_url", c.BaseURL); err != nil {
+		return err
+	}
+	if err := validateEndpoint("endpoint", c.Endpoint); err != nil {
+		return err
+	}
+	if err := validateEndpoint("dmapi_endpoint", c.DMAPIEndpoint); err != nil {
+		return err
+	}
+	for _, ep := range c.Endpoints {
+		if err := validateEndpoint("endpoints", ep); err != nil {
+			return err
+		}
+	}
+	if err := validateEndpoint("ip_detect_url", c.IPDetectURL); err != nil {
+		return err
+	}
+	if err := validateEndpoint("ipv6_detect_url", c.IPv6DetectURL); err != nil {
+		return err
+	}
+
+	switch strings.ToUpper(c.HTTPMethod) {
+	case "", http.MethodPost, http.MethodGet:
+	default:
+		return fmt.Errorf("unknown http_method %q: must be GET or POST", c.HTTPMethod)
+	}
+
+	switch c.ContentType {
+	case "", contentTypeForm:
+	case contentTypeJSON:
+		if strings.EqualFold(c.HTTPMethod, http.MethodGet) {
+			return fmt.Errorf("content_type %q requires http_method POST", c.ContentType)
+		}
+	default:
+		return fmt.Errorf("unknown content_type %q: must be %q or %q",
+			c.ContentType, contentTypeForm, contentTypeJSON)
+	}
+
+	for from, to := range c.FieldMap {
+		if !replaceFields[from] {
+			return fmt.Errorf("field_map: unknown field %q", from)
+		}
+		if to == "" {
+			return fmt.Errorf("field_map: empty name for field %q", from)
+		}
+	}
+
+	if c.APIKey != "" && c.APIToken != c.APIKey {
+		return fmt.Errorf("api_key and api_token are aliases; configure only one")
+	}
+
+	if err := (Credential{
+		Username: c.Username,
+		Password: c.Password,
+		APIToken: c.APIToken,
+	}).validate(); err != nil {
+		return err
+	}
+
+	for zone, cred := range c.ZoneCredentials {
+		if normalizeZone(zone) == "" {
+			return fmt.Errorf("zone_credentials: empty zone name")
+		}
+		if err := cred.validate(); err != nil {
+			return fmt.Errorf("zone_credentials %s: %w", zone, err)
+		}
+	}
+
+	return nil
+}
+
+// validateEndpoint checks that an endpoint override is an absolute http or
+// https URL. An empty value selects the default.
+func validateEndpoint(name, raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid %s %q: scheme must be http or https", name, raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid %s %q: must be an absolute URL with a host", name, raw)
+	}
+	return nil
+}
+
+// AppendRecords adds DNS records via Joker /nic/replace. Values already
+// present at a label/type are kept, so e.g. two ACME challenges can share
+// an _acme-challenge TXT name. RRsets are written concurrently, up to
+// Concurrency at a time; the first failure cancels the rest and the
+// returned records are those that succeeded, as Joker stores them (zone
+// relative name, the RRset's clamped TTL). Names are relative to zone; if
+// zone is empty they must be fully qualified, and each record's zone is
+// looked up among the account's domains and ZoneCredentials (as also for
+// SetRecords and DeleteRecords).
+func (c *Client) AppendRecords(
+	ctx context.Context,
+	zone string,
+	records []libdns.Record,
+) ([]libdns.Record, error) {
+	results, err := c.AppendRecordsDetailed(ctx, zone, records)
+
+	var added []libdns.Record
+	for _, r := range results {
+		if r.Err == nil {
+			added = append(added, r.Record)
+		}
+	}
+	return added, err
+}
+
+// AppendResult is the outcome of appending one record.
+type AppendResult struct {
+	// Record as Joker stores it if appended, otherwise as given
+	Record libdns.Record
+
+	// Created is false for a value that was already present
+	Created bool
+
+	// Err is why the record was not appended: its RRset's failure, or
+	// the call's error for RRsets never written or rolled back
+	Err error
+}
+
+// AppendRecordsDetailed works like AppendRecords but reports the outcome of
+// every record, including whether its value was new. Results are grouped
+// by RRset, in the order the RRsets completed. The error is the one
+// AppendRecords would return.
+func (c *Client) AppendRecordsDetailed(
+	ctx context.Context,
+	zone string,
+	records []libdns.Record,
+) ([]AppendResult, error) {
+	ctx = withRequestID(ctx)
+	if normalizeZone(zone) == "" {
+		return perZone(ctx, c, records, c.AppendRecordsDetailed)
+	}
+
+	if err := checkTypes(records); err != nil {
+		return nil, err
+	}
+	if err := checkNames(zone, records); err != nil {
+		return nil, err
+	}
+	if err := checkAddresses(records); err != nil {
+		return nil, err
+	}
+
+	grouped := groupRRSets(zone, records)
+
+	var (
+		mu        sync.Mutex
+		results   []AppendResult
+		done      = make(map[rrsetKey]bool)
+		addedSets []rrsetChange
+		changed   int
+		errs      []error
+	)
+
+	// failed records the outcome of an RRset that wasn't appended
+	failed := func(key rrsetKey, recs []libdns.Record, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		done[key] = true
+		for _, rec := range recs {
+			results = append(results, AppendResult{Record: rec, Err: err})
+		}
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency())
+
+	stopped := false
+	for key, recs := range grouped {
+		// Don't start further writes once cancelled or failed
+		if gctx.Err() != nil {
+			stopped = true
+			break
+		}
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+
+			values := c.rrsetValues(key.rtype, recs)
+			ttl := c.clampTTL(c.recordTTL(recs), key.zone, key.label, key.rtype)
+
+			c.log(ctx).Debug("adding DNS record",
+				zap.String("zone", key.zone),
+				zap.String("label", key.label),
+				zap.String("type", key.rtype),
+			)
+
+			prev, prevTTL, err := c.appendRRSet(
+				gctx,
+				key.zone,
+				key.label,
+				key.rtype,
+				values,
+				ttl,
+			)
+			if err != nil && !errors.Is(err, ErrNoChange) {
+				if c.ContinueOnError {
+					err = rrsetError(key, err)
+					failed(key, recs, err)
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					return nil
+				}
+				failed(key, recs, err)
+				return err
+			}
+
+			mu.Lock()
+			done[key] = true
+			for _, rec := range c.storedRecords(key, recs, ttl) {
+				results = append(results, AppendResult{
+					Record:  rec,
+					Created: err == nil && !c.containsAll(key.rtype, prev, []string{rec.RR().Data}),
+				})
+			}
+			addedSets = append(addedSets, rrsetChange{key: key, prev: prev, ttl: prevTTL})
+			if err == nil {
+				changed++
+			}
+			mu.Unlock()
+
+			return c.waitForPropagation(gctx, key, values)
+		})
+	}
+
+	err := g.Wait()
+	if err == nil && stopped {
+		err = ctx.Err()
+	}
+	if err == nil && len(errs) > 0 {
+		err = errors.Join(errs...)
+	}
+	for key, recs := range grouped {
+		if !done[key] {
+			failed(key, recs, err)
+		}
+	}
+	if err != nil && c.RollbackOnError && len(addedSets) > 0 {
+		c.rollback(ctx, addedSets)
+		for i := range results {
+			results[i].Created = false
+			if results[i].Err == nil {
+				results[i].Err = err
+			}
+		}
+		return results, err
+	}
+	if err == nil && c.ReportNoChange && len(addedSets) > 0 && changed == 0 {
+		err = ErrNoChange
+	}
+	return results, err
+}
+
+// rrsetChange records an RRset written by AppendRecords and the values and
+// TTL (in seconds) it held before, for rollback.
+type rrsetChange struct {
+	key  rrsetKey
+	prev []string
+	ttl  int
+}
+
+// rollback restores the RRsets written earlier in a failed AppendRecords
+// call to their previous values and TTL. It is best-effort: failures are
+// logged, not returned. It runs even if ctx was canceled, so a
+// cancellation doesn't leave records behind.
+func (c *Client) rollback(ctx context.Context, changes []rrsetChange) {
+	ctx = context.WithoutCancel(ctx)
+
+	for _, ch := range changes {
+		key := ch.key
+		c.log(ctx).Info("rolling back DNS record",
+			zap.String("zone", key.zone),
+			zap.String("label", key.label),
+			zap.String("type", key.rtype),
+		)
+
+		ttl := ch.ttl
+		if ttl <= 0 {
+			ttl = int(c.defaultTTL().Seconds())
+		}
+		rctx, cancel := c.rrsetContext(ctx)
+		err := ignoreNoChange(c.replaceRRSet(rctx, key.zone, key.label, key.rtype, ch.prev, ttl))
+		cancel()
+		if err != nil {
+			c.log(ctx).Warn("rollback failed",
+				zap.String("zone", key.zone),
+				zap.String("label", key.label),
+				zap.String("type", key.rtype),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// appendRRSet adds values to the label/type RRset while keeping the values
+// already there, and returns those previous values and their TTL in
+// seconds. The existing RRset is read via DMAPI; if that fails in dyndns
+// mode (e.g. the credentials only work for /nic/replace) it warns and
+// replaces the RRset as before.
+func (c *Client) appendRRSet(
+	ctx context.Context,
+	zone, label, rtype string,
+	values []string,
+	ttl int,
+) ([]string, int, error) {
+	unlock := c.lockRRSet(zone, label, rtype)
+	defer unlock()
+
+	ctx, cancel := c.rrsetContext(ctx)
+	defer cancel()
+
+	// Skipping a write needs no fresher view than GetRecords has
+	if c.SkipUnchanged && c.cache != nil {
+		have, haveTTL, err := c.rrsetValuesAt(ctx, zone, label, rtype, true)
+		if err == nil && c.containsAll(rtype, have, values) {
+			c.logUnchanged(ctx, zone, label, rtype)
+			return have, haveTTL, ErrNoChange
+		}
+	}
+
+	prev, prevTTL, err := c.rrsetValuesAt(ctx, zone, label, rtype, false)
+	if err != nil {
+		if c.Mode == modeDMAPI || dmapiOnlyTypes[rtype] {
+			return nil, 0, err
+		}
+		c.log(ctx).Warn("cannot read existing records; existing values will be replaced",
+			zap.String("zone", zone),
+			zap.String("label", label),
+			zap.String("type", rtype),
+			zap.Error(err),
+		)
+	}
+
+	if c.SkipUnchanged && err == nil && c.containsAll(rtype, prev, values) {
+		c.logUnchanged(ctx, zone, label, rtype)
+		return prev, prevTTL, ErrNoChange
+	}
+
+	merged := append(append([]string(nil), prev...), values...)
+	merged = dedupeValues(merged)
+
+	return prev, prevTTL, c.replaceRRSet(ctx, zone, label, rtype, merged, ttl)
+}
+
+// logUnchanged notes an RRset write skipped by SkipUnchanged.
+func (c *Client) logUnchanged(ctx context.Context, zone, label, rtype string) {
+	c.log(ctx).Debug("DNS record already present, not writing",
+		zap.String("zone", zone),
+		zap.String("label", label),
+		zap.String("type", rtype),
+	)
+}
+
+// deleteFromRRSet removes values (every value when all is set) from the
+// label/type RRset, rewriting it with whatever remains at its stored TTL,
+// and returns the values actually removed. If the RRset can't be read in dyndns mode it
+// warns and deletes the whole RRset, returning nil.
+func (c *Client) deleteFromRRSet(
+	ctx context.Context,
+	zone, label, rtype string,
+	values []string,
+	all bool,
+	ttl int,
+) ([]string, error) {
+	unlock := c.lockRRSet(zone, label, rtype)
+	defer unlock()
+
+	ctx, cancel := c.rrsetContext(ctx)
+	defer cancel()
+
+	current, curTTL, err := c.rrsetValuesAt(ctx, zone, label, rtype, false)
+	if err != nil {
+		if c.Mode == modeDMAPI || dmapiOnlyTypes[rtype] {
+			return nil, err
+		}
+		c.log(ctx).Warn("cannot read existing records; deleting the whole record set",
+			zap.String("zone", zone),
+			zap.String("label", label),
+			zap.String("type", rtype),
+			zap.Error(err),
+		)
+		return nil, ignoreNoChange(c.replaceRRSet(ctx, zone, label, rtype, nil, ttl))
+	}
+
+	var keep, removed []string
+	for _, v := range current {
+		if all || slices.Contains(values, v) {
+			removed = append(removed, v)
+		} else {
+			keep = append(keep, v)
+		}
+	}
+
+	if len(removed) == 0 {
+		// Nothing matched; non-nil so the caller knows nothing was deleted
+		return []string{}, nil
+	}
+	// The surviving values keep the TTL they were stored with
+	if curTTL > 0 {
+		ttl = curTTL
+	}
+	return removed, ignoreNoChange(c.replaceRRSet(ctx, zone, label, rtype, keep, ttl))
+}
+
+// rrsetValuesAt returns the values stored at label/type, and their TTL in
+// seconds (0 if there are none), from the cache only if cached is set (see
+// getRecords).
+func (c *Client) rrsetValuesAt(
+	ctx context.Context,
+	zone, label, rtype string,
+	cached bool,
+) ([]string, int, error) {
+	records, err := c.getRecords(ctx, zone, cached)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		values []string
+		ttl    int
+	)
+	for _, rec := range records {
+		rr := rec.RR()
+		if rr.Type == rtype && sameLabel(labelRelativeToZone(rr.Name, zone), label) {
+			values = append(values, rr.Data)
+			ttl = int(rr.TTL.Seconds())
+		}
+	}
+	return values, ttl, nil
+}
+
+// containsAll reports whether every value is already among have, as read
+// back from the zone.
+func (c *Client) containsAll(rtype string, have, values []string) bool {
+	for _, v := range values {
+		if !slices.ContainsFunc(have, func(h string) bool {
+			if rtype == "TXT" {
+				h = c.txtValue(h)
+			}
+			return h == v
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameLabel compares labels case-insensitively and regardless of IDN form.
+func sameLabel(a, b string) bool {
+	if aa, err := toASCII(a); err == nil {
+		a = aa
+	}
+	if bb, err := toASCII(b); err == nil {
+		b = bb
+	}
+	return strings.EqualFold(a, b)
+}
+
+// rrsetLocks serializes read-modify-write cycles on the same RRset within
+// this process.
+type rrsetLocks struct {
+	mu    sync.Mutex
+	locks map[rrsetKey]*sync.Mutex
+}
+
+// lockRRSet locks the RRset and returns its unlock function.
+func (c *Client) lockRRSet(zone, label, rtype string) func() {
+	if c.rrLocks == nil {
+		return func() {}
+	}
+
+	key := rrsetKey{zone: normalizeZone(zone), label: strings.ToLower(label), rtype: rtype}
+
+	c.rrLocks.mu.Lock()
+	l, ok := c.rrLocks.locks[key]
+	if !ok {
+		l = new(sync.Mutex)
+		c.rrLocks.locks[key] = l
+	}
+	c.rrLocks.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// SetRecords replaces each RRset named in records with exactly the given
+// values. Values already present at a label/type but absent from records
+// are removed, as Joker's /nic/replace swaps the whole RRset. RRsets that
+// GetRecords shows already hold those values and TTL are not rewritten.
+// The returned records reflect the stored name and TTL.
+func (c *Client) SetRecords(
+	ctx context.Context,
+	zone string,
+	records []libdns.Record,
+) ([]libdns.Record, error) {
+	ctx = withRequestID(ctx)
+	if normalizeZone(zone) == "" {
+		return perZone(ctx, c, records, c.SetRecords)
+	}
+
+	if err := checkTypes(records); err != nil {
+		return nil, err
+	}
+	if err := checkNames(zone, records); err != nil {
+		return nil, err
+	}
+	if err := checkAddresses(records); err != nil {
+		return nil, err
+	}
+
+	grouped := groupRRSets(zone, records)
+
+	// Diff against the zone so RRsets that already match aren't rewritten
+	current, err := c.getRecords(ctx, zone, false)
+	if err != nil {
+		c.log(ctx).Warn("cannot read existing records; every record set will be written",
+			zap.String("zone", zone),
+			zap.Error(err),
+		)
+		current = nil
+	}
+
+	var (
+		set     []libdns.Record
+		changed int
+		errs    []error
+	)
+
+	for key, recs := range grouped {
+		if err := ctx.Err(); err != nil {
+			return set, err
+		}
+
+		values := c.rrsetValues(key.rtype, recs)
+		ttl := c.clampTTL(c.recordTTL(recs), key.zone, key.label, key.rtype)
+
+		if c.rrsetMatches(current, key, values, ttl) {
+			c.log(ctx).Debug("DNS record unchanged, not setting",
+				zap.String("zone", key.zone),
+				zap.String("label", key.label),
+				zap.String("type", key.rtype),
+			)
+			set = append(set, c.storedRecords(key, recs, ttl)...)
+			continue
+		}
+
+		c.log(ctx).Debug("setting DNS record",
+			zap.String("zone", key.zone),
+			zap.String("label", key.label),
+			zap.String("type", key.rtype),
+		)
+
+		rctx, cancel := c.rrsetContext(ctx)
+		err := c.replaceRRSet(
+			rctx,
+			key.zone,
+			key.label,
+			key.rtype,
+			values,
+			ttl,
+		)
+		cancel()
+		if err != nil && !errors.Is(err, ErrNoChange) {
+			if c.ContinueOnError {
+				errs = append(errs, rrsetError(key, err))
+				continue
+			}
+			return set, err
+		}
+		if err == nil {
+			changed++
+		}
+
+		set = append(set, c.storedRecords(key, recs, ttl)...)
+
+		if err := c.waitForPropagation(ctx, key, values); err != nil {
+			if c.ContinueOnError {
+				errs = append(errs, rrsetError(key, err))
+				continue
+			}
+			return set, err
+		}
+	}
+
+	if len(errs) > 0 {
+		return set, errors.Join(errs...)
+	}
+	if c.ReportNoChange && len(set) > 0 && changed == 0 {
+		return set, ErrNoChange
+	}
+	return set, nil
+}
+
+// rrsetError identifies the RRset a failure belongs to, for errors joined
+// under ContinueOnError.
+func rrsetError(key rrsetKey, err error) error {
+	return fmt.Errorf("%s %s in %s: %w", key.label, key.rtype, key.zone, err)
+}
+
+// rrsetMatches reports whether records, as returned by GetRecords, hold
+// exactly values with the given TTL at key's label/type.
+func (c *Client) rrsetMatches(records []libdns.Record, key rrsetKey, values []string, ttl int) bool {
+	var current []string
+	for _, rec := range records {
+		rr := rec.RR()
+		if rr.Type != key.rtype || !sameLabel(labelRelativeToZone(rr.Name, key.zone), key.label) {
+			continue
+		}
+		if int(rr.TTL.Seconds()) != ttl {
+			return false
+		}
+		v := rr.Data
+		if key.rtype == "TXT" {
+			v = c.txtValue(v)
+		}
+		current = append(current, v)
+	}
+	if len(current) == 0 {
+		return false
+	}
+
+	current = dedupeValues(current)
+	want := slices.Clone(values)
+	slices.Sort(current)
+	slices.Sort(want)
+	return slices.Equal(current, want)
+}
+
+// DeleteRecords deletes DNS records via Joker /nic/replace. Only the
+// given values are removed; other values at the same label/type survive.
+// A record with empty data deletes every value of its label/type.
+func (c *Client) DeleteRecords(
+	ctx context.Context,
+	zone string,
+	records []libdns.Record,
+) ([]libdns.Record, error) {
+	ctx = withRequestID(ctx)
+	if normalizeZone(zone) == "" {
+		return perZone(ctx, c, records, c.DeleteRecords)
+	}
+
+	if err := checkTypes(records); err != nil {
+		return nil, err
+	}
+	if err := checkNames(zone, records); err != nil {
+		return nil, err
+	}
+
+	grouped := groupRRSets(zone, records)
+
+	var (
+		deleted []libdns.Record
+		errs    []error
+	)
+
+	for key, recs := range grouped {
+		// Stop promptly on cancellation rather than at the next request
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		ttl := c.recordTTL(recs)
+
+		c.log(ctx).Debug("deleting DNS record",
+			zap.String("zone", key.zone),
+			zap.String("label", key.label),
+			zap.String("type", key.rtype),
+		)
+
+		all := false
+		for _, rec := range recs {
+			if rec.RR().Data == "" {
+				all = true
+			}
+		}
+
+		removed, err := c.deleteFromRRSet(
+			ctx,
+			key.zone,
+			key.label,
+			key.rtype,
+			c.rrsetValues(key.rtype, recs),
+			all,
+			ttl,
+		)
+		if err != nil {
+			if c.ContinueOnError {
+				errs = append(errs, rrsetError(key, err))
+				continue
+			}
+			return deleted, err
+		}
+
+		for _, rec := range recs {
+			v := rec.RR().Data
+			if key.rtype == "TXT" {
+				v = c.txtValue(v)
+			}
+			if removed == nil || all || slices.Contains(removed, v) {
+				deleted = append(deleted, rec)
+			}
+		}
+	}
+
+	return deleted, errors.Join(errs...)
+}
+
+// DeleteAllAtName deletes every rtype record at name, e.g. all the
+// _acme-challenge TXT values left by earlier challenges, with one rewrite
+// of the RRset, and returns the records removed. As for DeleteRecords,
+// name is relative to zone, or fully qualified if zone is empty. If the
+// RRset can't be read in dyndns mode it is still deleted, but nil is
+// returned as what was removed is unknown.
+func (c *Client) DeleteAllAtName(
+	ctx context.Context,
+	zone, name, rtype string,
+) ([]libdns.Record, error) {
+	ctx = withRequestID(ctx)
+	rtype = strings.ToUpper(strings.TrimSpace(rtype))
+
+	if normalizeZone(zone) == "" {
+		z, err := c.zoneOf(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		zone = z
+	}
+
+	target := []libdns.Record{libdns.RR{Name: name, Type: rtype}}
+	if err := checkTypes(target); err != nil {
+		return nil, err
+	}
+	if err := checkNames(zone, target); err != nil {
+		return nil, err
+	}
+
+	key := rrsetKey{
+		zone:  normalizeZone(zone),
+		label: labelRelativeToZone(name, zone),
+		rtype: rtype,
+	}
+	c.log(ctx).Debug("deleting all DNS records at name",
+		zap.String("zone", key.zone),
+		zap.String("label", key.label),
+		zap.String("type", key.rtype),
+	)
+
+	ttl := int(c.defaultTTL().Seconds())
+	removed, err := c.deleteFromRRSet(ctx, key.zone, key.label, key.rtype, nil, true, ttl)
+	if err != nil || removed == nil {
+		return nil, err
+	}
+
+	deleted := make([]libdns.Record, 0, len(removed))
+	for _, v := range removed {
+		deleted = append(deleted, typedRecord(libdns.RR{
+			Name: key.label,
+			Type: key.rtype,
+			Data: v,
+		}))
+	}
+	return deleted, nil
+}
+
+// rrsetContext bounds one RRset operation, reads and writes together, by
+// PerRequestTimeout, separately from the caller's deadline.
+func (c *Client) rrsetContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.PerRequestTimeout > 0 {
+		return context.WithTimeout(ctx, time.Duration(c.PerRequestTimeout))
+	}
+	return ctx, func() {}
+}
+
+// replaceRRSet calls Joker's /nic/replace endpoint, or DMAPI for record
+// types /nic/replace cannot express. An empty value deletes the record.
+// ErrNoChange means success with the RRset already as requested.
+func (c *Client) replaceRRSet(
+	ctx context.Context,
+	zone, label, rtype string,
+	values []string,
+	ttl int,
+) error {
+	start := time.Now()
+	err := c.doReplaceRRSet(ctx, zone, label, rtype, values, ttl)
+	observeRequest(rtype, start, ignoreNoChange(err))
+	return err
+}
+
+// ignoreNoChange maps ErrNoChange, which is a success, to nil.
+func ignoreNoChange(err error) error {
+	if errors.Is(err, ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) doReplaceRRSet(
+	ctx context.Context,
+	zone, label, rtype string,
+	values []string,
+	ttl int,
+) error {
+	zone = normalizeZone(zone)
+	// The apex, however it was given, is "@" for Joker
+	label = labelRelativeToZone(label, zone)
+
+	uZone, uLabel := zone, label
+	zone, err := toASCII(zone)
+	if err != nil {
+		return err
+	}
+	label, err = toASCII(label)
+	if err != nil {
+		return err
+	}
+	// Whatever the outcome, the zone may have changed
+	defer c.cache.invalidate(zone)
+
+	if zone != uZone || label != uLabel {
+		observeRewrite("punycode")
+		c.log(ctx).Debug("converted name to punycode",
+			zap.String("before", joinName(uLabel, uZone)),
+			zap.String("after", joinName(label, zone)),
+		)
+	}
+
+	ttl = c.clampTTL(ttl, zone, label, rtype)
+
+	if c.DryRun {
+		// Prove the credentials work without changing anything
+		if _, err := c.dmapiAuth(ctx, zone); err != nil {
+			return err
+		}
+		c.log(ctx).Info("dry run: not replacing DNS record",
+			zap.String("zone", zone),
+			zap.String("label", label),
+			zap.String("type", rtype),
+			zap.Int("ttl", ttl),
+			zap.Strings("values", values),
+		)
+		return nil
+	}
+
+	if c.Mode == modeDMAPI || dmapiOnlyTypes[rtype] {
+		return c.dmapiReplaceRRSet(ctx, zone, label, rtype, values, ttl)
+	}
+
+	if !nicTypes[rtype] && c.nicWarning != nil {
+		c.nicWarning.Do(func() {
+			c.log(ctx).Warn("writing non-address, non-TXT records through the dynamic DNS endpoint; "+
+				"consider mode dmapi, which manages all record types fully",
+				zap.String("type", rtype),
+			)
+		})
+	}
+
+	// Checked against the values as GetRecords reports them, before chunking
+	wctx := c.withWriteCheck(ctx, zone, label, rtype, values, ttl)
+
+	if rtype == "TXT" && !c.RawTXT {
+		chunked := make([]string, len(values))
+		for i, v := range values {
+			chunked[i] = chunkTXT(v)
+			if chunked[i] != v {
+				observeRewrite("txt_chunk")
+				c.log(ctx).Debug("split long TXT value into strings",
+					zap.String("zone", zone),
+					zap.String("label", label),
+					zap.Int("length", len(v)),
+					zap.String("after", chunked[i]),
+				)
+			}
+		}
+		values = chunked
+	}
+
+	cred, _ := c.authFor(zone)
+	form := url.Values{}
+	if cred.APIToken != "" {
+		form.Set("api_token", cred.APIToken)
+	} else {
+		form.Set("username", cred.Username)
+		form.Set("password", cred.Password)
+	}
+
+	form.Set("zone", zone)
+	form.Set("label", label)
+	form.Set("type", rtype)
+	form.Set("ttl", strconv.Itoa(ttl))
+
+	if len(values) > 0 {
+		form.Set("value", strings.Join(values, ","))
+	} else {
+		form.Set("value", "")
+	}
+
+	record := []zap.Field{
+		zap.String("zone", zone),
+		zap.String("label", label),
+		zap.String("type", rtype),
+		zap.Int("ttl", ttl),
+		zap.Int("values", len(values)),
+	}
+
+	form = c.mapFields(form)
+
+	// Safe debug logging (no secrets)
+	c.logFormRedacted(form)
+
+	endpoint, status, rawBody, err := c.postReplace(wctx, form, record)
+	if errors.Is(err, errAlreadyApplied) {
+		return nil
+	}
+	if err != nil {
+		c.log(ctx).Warn("joker request failed",
+			zap.String("zone", zone),
+			zap.String("label", label),
+			zap.String("type", rtype),
+			zap.String("endpoint", endpoint),
+			zap.String("error", c.redact(err.Error())),
+		)
+		return err
+	}
+
+	// Joker may echo request parameters; never let credentials escape
+	body := c.redact(string(rawBody))
+
+	if !c.successStatus(status) {
+		c.log(ctx).Warn("joker API error",
+			zap.String("zone", zone),
+			zap.String("label", label),
+			zap.String("type", rtype),
+			zap.Int("status", status),
+			zap.String("response", body),
+		)
+		return newStatusError(status, strings.TrimSpace(body))
+	}
+
+	// A proxy answering e.g. 204 may not pass Joker's body on
+	if status != http.StatusOK && strings.TrimSpace(body) == "" {
+		return nil
+	}
+
+	if c.ContentType == contentTypeJSON {
+		body = jsonReplaceResponse(body)
+	}
+
+	err = checkReplaceResponse(body)
+	if errors.Is(err, ErrNoChange) {
+		c.log(ctx).Debug("joker reports no change",
+			zap.String("zone", zone),
+			zap.String("label", label),
+			zap.String("type", rtype),
+		)
+		return err
+	}
+	if err != nil {
+		c.log(ctx).Warn("joker API rejected update",
+			zap.String("zone", zone),
+			zap.String("label", label),
+			zap.String("type", rtype),
+			zap.String("response", body),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+// successStatus reports whether a /nic/replace HTTP status counts as
+// success: one of SuccessStatuses, or 200 by default.
+func (c *Client) successStatus(status int) bool {
+	if len(c.SuccessStatuses) == 0 {
+		return status == http.StatusOK
+	}
+	return slices.Contains(c.SuccessStatuses, status)
+}
+
+// nicTypes are the record types /nic/replace is designed for; others are
+// accepted but are better written via DMAPI.
+var nicTypes = map[string]bool{
+	"A":    true,
+	"AAAA": true,
+	"TXT":  true,
+}
+
+// replaceResponseErrors maps the dyndns style status tokens Joker may
+// return with HTTP 200 to descriptive errors.
+var replaceResponseErrors = map[string]error{
+	"badauth":  ErrAuth,
+	"notfqdn":  errors.New("hostname is not a fully qualified domain name"),
+	"nohost":   fmt.Errorf("hostname does not exist in this account: %w", ErrNotFound),
+	"numhost":  errors.New("too many hosts in update"),
+	"abuse":    errors.New("updates blocked for abuse"),
+	"badagent": errors.New("user agent rejected"),
+	"dnserr":   errors.New("DNS error on server"),
+	"911":      errors.New("server error, retry later"),
+}
+
+// checkReplaceResponse inspects a /nic/replace body returned with HTTP 200.
+// The leading token is compared case-insensitively: "ok" and "good" are
+// success, "nochg" is ErrNoChange, "ko" and the known dyndns error tokens
+// are failures. Any other body is an error too, so an unexpected page
+// served with 200 (e.g. by a proxy) isn't mistaken for success.
+func checkReplaceResponse(body string) error {
+	body = strings.TrimSpace(body)
+
+	token := body
+	if i := strings.IndexAny(token, ": \r\n"); i >= 0 {
+		token = token[:i]
+	}
+	token = strings.ToLower(token)
+
+	switch token {
+	case "ok", "good":
+		return nil
+	case "nochg":
+		return ErrNoChange
+	case "ko":
+		e := &APIError{StatusCode: http.StatusOK, Body: body}
+		if strings.Contains(strings.ToLower(body), "authenticat") {
+			e.Err = ErrAuth
+		}
+		return e
+	}
+
+	if err, ok := replaceResponseErrors[token]; ok {
+		return &APIError{StatusCode: http.StatusOK, Body: body, Err: err}
+	}
+
+	return &APIError{
+		StatusCode: http.StatusOK,
+		Body:       body,
+		Err:        errors.New("unexpected response"),
+	}
+}
+
+// jsonReplaceResponse turns a JSON answer such as {"status":"nochg"} or
+// {"result":"ko","message":"..."} into the plain text form
+// checkReplaceResponse understands. Anything else is returned as is, and
+// so reported as unexpected unless it is plain text.
+func jsonReplaceResponse(body string) string {
+	var resp struct {
+		Status  string `json:"status"`
+		Result  string `json:"result"`
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		return body
+	}
+
+	token := cmp.Or(resp.Status, resp.Result)
+	if token == "" {
+		return body
+	}
+	if msg := cmp.Or(resp.Message, resp.Error); msg != "" {
+		return token + ": " + msg
+	}
+	return token
+}
+
+// httpResult is the outcome of a single HTTP exchange.
+type httpResult struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// endpoints returns the /nic/replace endpoints in the order they are
+// tried.
+func (c *Client) endpoints() []string {
+	var eps []string
+	if c.Endpoint != "" {
+		eps = append(eps, c.Endpoint)
+	}
+	for _, ep := range c.Endpoints {
+		if !slices.Contains(eps, ep) {
+			eps = append(eps, ep)
+		}
+	}
+	if len(eps) == 0 {
+		eps = append(eps, defaultEndpoint)
+	}
+	return eps
+}
+
+// postReplace posts form to each endpoint in turn until one answers with
+// something other than a network error or 5xx, returning the endpoint that
+// produced the result. Each attempt is logged at debug level with record,
+// the fields describing the RRset written.
+func (c *Client) postReplace(
+	ctx context.Context,
+	form url.Values,
+	record []zap.Field,
+) (string, int, []byte, error) {
+	eps := c.endpoints()
+
+	out := formOutgoing(form)
+	if c.ContentType == contentTypeJSON {
+		var err error
+		if out, err = jsonOutgoing(form); err != nil {
+			return "", 0, nil, err
+		}
+	}
+	out.replace = true
+
+	var (
+		endpoint string
+		status   int
+		body     []byte
+		err      error
+	)
+	for i := range eps {
+		endpoint = eps[i]
+		c.log(ctx).Debug("joker replace", append(slices.Clip(record), zap.String("endpoint", endpoint))...)
+		status, body, err = c.send(ctx, c.replaceMethod(), endpoint, out)
+
+		failover := err != nil || status >= http.StatusInternalServerError
+		if !failover || errors.Is(err, errAlreadyApplied) || i == len(eps)-1 || ctx.Err() != nil {
+			break
+		}
+
+		c.log(ctx).Warn("joker endpoint failed, trying next",
+			zap.String("endpoint", endpoint),
+			zap.String("next", eps[i+1]),
+			zap.Int("status", status),
+		)
+	}
+	return endpoint, status, body, err
+}
+
+func (c *Client) replaceMethod() string {
+	if strings.EqualFold(c.HTTPMethod, http.MethodGet) {
+		return http.MethodGet
+	}
+	return http.MethodPost
+}
+
+// postForm POSTs the urlencoded form to endpoint and returns the HTTP
+// status and response body, retrying transient failures.
+func (c *Client) postForm(
+	ctx context.Context,
+	endpoint string,
+	form url.Values,
+) (int, []byte, error) {
+	return c.sendForm(ctx, http.MethodPost, endpoint, form)
+}
+
+// sendForm sends form with retries, as a urlencoded body for POST or as
+// the query string for GET.
+func (c *Client) sendForm(
+	ctx context.Context,
+	method string,
+	endpoint string,
+	form url.Values,
+) (int, []byte, error) {
+	return c.send(ctx, method, endpoint, formOutgoing(form))
+}
+
+func formOutgoing(form url.Values) outgoing {
+	return outgoing{
+		form:        form,
+		contentType: "application/x-www-form-urlencoded",
+		payload:     form.Encode(),
+	}
+}
+
+// jsonOutgoing encodes form as a JSON object of its fields.
+func jsonOutgoing(form url.Values) (outgoing, error) {
+	fields := make(map[string]string, len(form))
+	for k := range form {
+		fields[k] = form.Get(k)
+	}
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return outgoing{}, err
+	}
+	return outgoing{
+		form:        form,
+		contentType: "application/json",
+		payload:     string(payload),
+	}, nil
+}
+
+func (c *Client) send(
+	ctx context.Context,
+	method string,
+	endpoint string,
+	out outgoing,
+) (int, []byte, error) {
+	res, err := c.withRetry(ctx, func() (*httpResult, error) {
+		return c.doRequest(ctx, method, endpoint, out)
+	})
+	if res == nil {
+		return 0, nil, err
+	}
+	return res.status, res.body, err
+}
+
+// outgoing is what one request carries: the form, kept for tracing, and
+// its encoding on the wire. A GET sends the payload as the query string.
+// replace marks /nic/replace requests, which get the Host and TLS name
+// overrides.
+type outgoing struct {
+	form        url.Values
+	contentType string
+	payload     string
+	replace     bool
+}
+
+// doRequest performs a single request carrying an encoded form, first
+// waiting for the rate limiter if one is configured, unless the circuit
+// breaker of its API is open.
+func (c *Client) doRequest(
+	ctx context.Context,
+	method string,
+	endpoint string,
+	out outgoing,
+) (*httpResult, error) {
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, err
+		}
+	}
+
+	b := c.dmapiBreaker
+	if out.replace {
+		b = c.replaceBreaker
+	}
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	res, err := c.sendRequest(ctx, method, endpoint, out)
+	b.record(ctx, res, err)
+	return res, err
+}
+
+// sendRequest sends one request and reads its response.
+func (c *Client) sendRequest(
+	ctx context.Context,
+	method string,
+	endpoint string,
+	out outgoing,
+) (*httpResult, error) {
+	target := endpoint
+	var body io.Reader
+	if method == http.MethodGet {
+		sep := "?"
+		if strings.Contains(endpoint, "?") {
+			sep = "&"
+		}
+		target = endpoint + sep + out.payload
+	} else {
+		body = strings.NewReader(out.payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", out.contentType)
+	}
+	if out.replace && c.HostHeader != "" {
+		req.Host = c.HostHeader
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if c.SendRequestID {
+		if id := requestID(ctx); id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+	}
+
+	c.traceRequest(method, endpoint, out.form)
+
+	client := c.httpClient()
+	if out.replace && c.replaceClient != nil {
+		client = c.replaceClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// Report the caller's cancellation or deadline rather than the
+		// transport's wrapping of it
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		// The query string of a GET carries the credentials
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			uerr.URL = endpoint
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	res := &httpResult{
+		status: resp.StatusCode,
+		header: resp.Header,
+	}
+	res.body, err = readBody(resp)
+	if err == nil {
+		c.traceResponse(method, endpoint, res)
+	}
+	return res, err
+}
+
+// readBody reads a response body, decompressing it if it is gzip encoded.
+// Setting Accept-Encoding ourselves disables the transport's transparent
+// decompression, and some proxies compress bodies even when Joker doesn't.
+// A body labelled gzip that isn't is returned as is.
+func readBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return body, err
+	}
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") &&
+		!bytes.HasPrefix(body, gzipMagic) {
+		return body, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return body, nil
+	}
+	defer zr.Close()
+
+	decoded, err := io.ReadAll(zr)
+	if err != nil {
+		return body, nil
+	}
+	return decoded, nil
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// txtValue returns a TXT value as it is to be stored: normalized, or
+// untouched with RawTXT.
+func (c *Client) txtValue(v string) string {
+	if c.RawTXT {
+		return v
+	}
+	return normalizeTXT(v)
+}
+
+// normalizeTXT removes a single surrounding pair of quotes from TXT values
+// if present. Zone file style chunked values ("abc" "def") are joined
+// into one string.
+func normalizeTXT(v string) string {
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return v
+	}
+	if chunks, ok := splitQuotedStrings(v); ok {
+		return strings.Join(chunks, "")
+	}
+	return v[1 : len(v)-1]
+}
+
+// maxTXTChunk is the longest character-string a TXT record may hold
+// (RFC 1035 section 3.3.14).
+const maxTXTChunk = 255
+
+// chunkTXT splits a TXT value longer than maxTXTChunk bytes into quoted
+// character-strings of at most maxTXTChunk bytes each, as RFC 7208
+// section 3.3 describes for long SPF/DKIM records. Shorter values are
+// returned unchanged.
+func chunkTXT(v string) string {
+	if len(v) <= maxTXTChunk {
+		return v
+	}
+
+	var b strings.Builder
+	for len(v) > 0 {
+		n := min(len(v), maxTXTChunk)
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteByte('"')
+		for i := 0; i < n; i++ {
+			if v[i] == '"' || v[i] == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(v[i])
+		}
+		b.WriteByte('"')
+		v = v[n:]
+	}
+	return b.String()
+}
+
+// splitQuotedStrings parses a whitespace separated sequence of double
+// quoted character-strings, honoring \" and \\ escapes. ok is false if v
+// is not entirely made of such strings.
+func splitQuotedStrings(v string) (chunks []string, ok bool) {
+	var cur strings.Builder
+
+	for i := 0; i < len(v); {
+		switch v[i] {
+		case ' ', '\t':
+			i++
+			continue
+		case '"':
+		default:
+			return nil, false
+		}
+
+		cur.Reset()
+		closed := false
+		for i++; i < len(v); i++ {
+			c := v[i]
+			if c == '\\' && i+1 < len(v) {
+				i++
+				cur.WriteByte(v[i])
+				continue
+			}
+			if c == '"' {
+				closed = true
+				i++
+				break
+			}
+			cur.WriteByte(c)
+		}
+		if !closed {
+			return nil, false
+		}
+		chunks = append(chunks, cur.String())
+	}
+	return chunks, true
+}
+
+// TTLFromSeconds converts a TTL in seconds to the time.Duration that
+// libdns records carry, e.g. TTLFromSeconds(300) for five minutes. Setting
+// TTL: 300 directly means 300 nanoseconds.
+func TTLFromSeconds(seconds int) time.Duration {
+	return time.Duration(seconds) * time.Second
+}
+
+// ttlSeconds converts a record TTL to whole seconds. A positive TTL under
+// one second can't be meant literally and is almost certainly a count of
+// seconds assigned to the Duration as a bare integer, so it is read as
+// seconds; suspect reports that case.
+func ttlSeconds(ttl time.Duration) (secs int, suspect bool) {
+	if ttl > 0 && ttl < time.Second {
+		return int(ttl), true
+	}
+	return int(ttl.Seconds()), false
+}
+
+// Select the minimum TTL of all records, as with joker single update they
+// must share one TTL. Records with a zero TTL use def instead. The result
+// is not yet clamped to the Joker permitted range; see clampTTL.
+func minTTL(records []libdns.Record, def time.Duration) int {
+	if len(records) == 0 {
+		return int(def.Seconds())
+	}
+
+	ttlOf := func(r libdns.Record) int {
+		if ttl := r.RR().TTL; ttl != 0 {
+			secs, _ := ttlSeconds(ttl)
+			return secs
+		}
+		return int(def.Seconds())
+	}
+
+	min := ttlOf(records[0])
+	for _, r := range records[1:] {
+		if t := ttlOf(r); t < min {
+			min = t
+		}
+	}
+
+	return min
+}
+
+// AllowedTypes is the set of record types Joker accepts. Records of any
+// other type are rejected before a request is made. Callers may extend it
+// (before first use) if Joker adds types.
+var AllowedTypes = map[string]bool{
+	"A":     true,
+	"AAAA":  true,
+	"CNAME": true,
+	"TXT":   true,
+	"MX":    true,
+	"NS":    true,
+	"SRV":   true,
+	"CAA":   true,
+	"NAPTR": true,
+}
+
+// SupportedRecordTypes returns the record types the Client accepts,
+// sorted: the keys of AllowedTypes, which also decide what AppendRecords,
+// SetRecords and DeleteRecords reject.
+func (c *Client) SupportedRecordTypes() []string {
+	return slices.Sorted(maps.Keys(AllowedTypes))
+}
+
+// unsupportedTypeReasons explains why some commonly requested types are
+// not in AllowedTypes.
+var unsupportedTypeReasons = map[string]string{
+	"PTR": "Joker only serves zones of domains registered with it; reverse " +
+		"(in-addr.arpa/ip6.arpa) zones are delegated by the address holder " +
+		"and can't be hosted there",
+	"ALIAS": "Joker has no ALIAS/ANAME record type; point the apex at " +
+		"addresses with A/AAAA records, or use Joker's URL forwarding",
+	"ANAME": "Joker has no ALIAS/ANAME record type; point the apex at " +
+		"addresses with A/AAAA records, or use Joker's URL forwarding",
+	"HTTPS": "Joker's zone format has no HTTPS/SVCB (RFC 9460) record type",
+	"SVCB":  "Joker's zone format has no HTTPS/SVCB (RFC 9460) record type",
+}
+
+// checkTypes returns an error naming the first record whose type is not
+// in AllowedTypes.
+func checkTypes(records []libdns.Record) error {
+	for _, rec := range records {
+		rr := rec.RR()
+		if AllowedTypes[rr.Type] {
+			continue
+		}
+		if reason, ok := unsupportedTypeReasons[rr.Type]; ok {
+			return fmt.Errorf("unsupported record type %q for %q: %s", rr.Type, rr.Name, reason)
+		}
+		return fmt.Errorf("unsupported record type %q for %q", rr.Type, rr.Name)
+	}
+	return nil
+}
+
+// checkNames rejects absolute names (with a trailing dot) that lie outside
+// zone, which would otherwise be written as a label inside it. Names
+// without a trailing dot are relative to zone, as libdns specifies.
+func checkNames(zone string, records []libdns.Record) error {
+	z := strings.ToLower(asciiOrSelf(normalizeZone(zone)))
+	for _, rec := range records {
+		name := strings.TrimSpace(rec.RR().Name)
+		if !strings.HasSuffix(name, ".") {
+			continue
+		}
+		fqdn := strings.ToLower(asciiOrSelf(strings.TrimRight(name, ".")))
+		if fqdn != z && !strings.HasSuffix(fqdn, "."+z) {
+			return fmt.Errorf("record name %q is not within zone %q", name, zone)
+		}
+	}
+	return nil
+}
+
+// checkAddresses verifies that A records hold IPv4 and AAAA records hold
+// IPv6 addresses, catching the common mix-up before Joker silently
+// rejects it.
+func checkAddresses(records []libdns.Record) error {
+	for _, rec := range records {
+		rr := rec.RR()
+		if rr.Type != "A" && rr.Type != "AAAA" {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(strings.TrimSpace(rr.Data))
+		if err != nil {
+			return fmt.Errorf("%s record %q: invalid IP address %q", rr.Type, rr.Name, rr.Data)
+		}
+
+		switch {
+		case rr.Type == "A" && !addr.Is4():
+			return fmt.Errorf("A record %q: %s is not an IPv4 address; use an AAAA record", rr.Name, rr.Data)
+		case rr.Type == "AAAA" && (!addr.Is6() || addr.Is4In6()):
+			return fmt.Errorf("AAAA record %q: %s is not an IPv6 address; use an A record", rr.Name, rr.Data)
+		}
+	}
+	return nil
+}
+
+// defaultTTL returns the TTL used for records without one.
+func (c *Client) defaultTTL() time.Duration {
+	if c.DefaultTTL <= 0 {
+		return defaultTTL
+	}
+	return time.Duration(c.DefaultTTL)
+}
+
+// recordTTL returns the TTL (seconds) for an RRset written from records:
+// ForceTTL if set, otherwise their lowest TTL with DefaultTTL standing in
+// for records without one.
+func (c *Client) recordTTL(records []libdns.Record) int {
+	if c.ForceTTL > 0 {
+		return int(time.Duration(c.ForceTTL).Seconds())
+	}
+	for _, rec := range records {
+		rr := rec.RR()
+		if secs, suspect := ttlSeconds(rr.TTL); suspect {
+			c.baseLogger().Warn("record TTL is under a second; assuming it was meant in seconds "+
+				"(libdns TTLs are time.Duration, see TTLFromSeconds)",
+				zap.String("name", rr.Name),
+				zap.Duration("ttl", rr.TTL),
+				zap.Int("seconds", secs),
+			)
+		}
+	}
+	return minTTL(records, c.defaultTTL())
+}
+
+// clampTTL brings ttl (seconds) within the configured bounds, logging a
+// warning when it has to change it.
+func (c *Client) clampTTL(ttl int, zone, label, rtype string) int {
+	lo, hi := int(defaultMinTTL.Seconds()), int(defaultMaxTTL.Seconds())
+	if c.MinTTL > 0 {
+		lo = int(time.Duration(c.MinTTL).Seconds())
+	}
+	if c.MaxTTL > 0 {
+		hi = int(time.Duration(c.MaxTTL).Seconds())
+	}
+
+	clamped := min(max(ttl, lo), hi)
+	if clamped != ttl {
+		observeRewrite("ttl_clamp")
+		c.baseLogger().Warn("TTL out of range, clamping",
+			zap.String("zone", zone),
+			zap.String("label", label),
+			zap.String("type", rtype),
+			zap.Int("ttl", ttl),
+			zap.Int("clamped", clamped),
+		)
+	}
+	return clamped
+}
+
+// storedRecords describes recs as Joker stores them after a write: the
+// zone-relative label, the shared (clamped) TTL and normalized data.
+func (c *Client) storedRecords(key rrsetKey, recs []libdns.Record, ttl int) []libdns.Record {
+	out := make([]libdns.Record, 0, len(recs))
+	for _, rec := range recs {
+		rr := rec.RR()
+		if key.rtype == "TXT" {
+			rr.Data = c.txtValue(rr.Data)
+		}
+		out = append(out, typedRecord(libdns.RR{
+			Name: key.label,
+			Type: key.rtype,
+			TTL:  time.Duration(ttl) * time.Second,
+			Data: rr.Data,
+		}))
+	}
+	return out
+}
+
+// typedRecord returns rr as the libdns type for its record type (e.g.
+// libdns.Address, libdns.TXT, libdns.MX) so callers can type-switch on
+// it, or as the plain RR for types libdns has no struct for or data that
+// doesn't parse.
+func typedRecord(rr libdns.RR) libdns.Record {
+	rec, err := rr.Parse()
+	if err != nil {
+		return rr
+	}
+	return rec
+}
+
+// rrsetValues returns the values to send for an RRset, normalizing TXT
+// data and dropping exact duplicates (some ACME clients pass the same
+// record twice) while keeping the original order.
+func (c *Client) rrsetValues(rtype string, recs []libdns.Record) []string {
+	values := make([]string, 0, len(recs))
+
+	for _, rec := range recs {
+		rr := rec.RR()
+		v := rr.Data
+		if rtype == "TXT" {
+			v = c.txtValue(v)
+			if v != rr.Data {
+				observeRewrite("txt_unquote")
+				c.baseLogger().Debug("normalized TXT value",
+					zap.String("name", rr.Name),
+					zap.String("before", rr.Data),
+					zap.String("after", v),
+				)
+			}
+		}
+		values = append(values, v)
+	}
+	return dedupeValues(values)
+}
+
+// dedupeValues drops repeated values, keeping the first occurrence.
+func dedupeValues(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := values[:0]
+
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+// concurrency returns the configured parallelism, applying the default.
+func (c *Client) concurrency() int {
+	if c.Concurrency <= 0 {
+		return defaultConcurrency
+	}
+	return c.Concurrency
+}
+
+// groupRRSets buckets records by zone, label and type so each RRset can
+// be written with a single /nic/replace call.
+func groupRRSets(zone string, records []libdns.Record) map[rrsetKey][]libdns.Record {
+	grouped := make(map[rrsetKey][]libdns.Record)
+	z := normalizeZone(zone)
+
+	for _, rec := range records {
+		rr := rec.RR()
+		key := rrsetKey{
+			zone:  z,
+			label: labelRelativeToZone(rr.Name, z),
+			rtype: rr.Type,
+		}
+		grouped[key] = append(grouped[key], rec)
+	}
+	return grouped
+}
+
+// defaultUserAgent identifies this module, including its version when
+// the binary carries module build info.
+func defaultUserAgent() string {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				version = dep.Version
+				break
+			}
+		}
+		if info.Main.Path == modulePath && info.Main.Version != "" {
+			version = info.Main.Version
+		}
+	}
+	return "caddy-dns-joker/" + version + " (libdns)"
+}
+
+// idnaProfile converts between U-labels and A-labels. Unlike idna.Lookup
+// it permits underscores and wildcards, as used by _acme-challenge and
+// "*" records.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+)
+
+// toASCII converts an internationalized name such as "münchen.de" to its
+// punycode form "xn--mnchen-3ya.de". ASCII input is returned lowercased.
+// A leading "*" wildcard label is kept as is; one anywhere else is an
+// error.
+func toASCII(name string) (string, error) {
+	if name == "@" || name == "*" {
+		return name, nil
+	}
+	wildcard, rest := splitWildcard(name)
+	if strings.Contains(rest, "*") {
+		return "", fmt.Errorf("invalid domain name %q: wildcard must be the leftmost label", name)
+	}
+	a, err := idnaProfile.ToASCII(rest)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain name %q: %w", name, err)
+	}
+	return wildcard + a, nil
+}
+
+// toUnicode converts punycode labels back to their Unicode form, leaving
+// the name untouched if it cannot be decoded.
+func toUnicode(name string) string {
+	wildcard, rest := splitWildcard(name)
+	if rest == "" {
+		return name
+	}
+	u, err := idnaProfile.ToUnicode(rest)
+	if err != nil {
+		return name
+	}
+	return wildcard + u
+}
+
+// splitWildcard splits a leading "*." wildcard label off name.
+func splitWildcard(name string) (wildcard, rest string) {
+	if name == "*" {
+		return name, ""
+	}
+	if strings.HasPrefix(name, "*.") {
+		return "*.", name[2:]
+	}
+	return "", name
+}
+
+// normalizeZone trims surrounding whitespace and trailing dots so that
+// "example.com", "example.com." and "Example.COM." all address one zone.
+func normalizeZone(z string) string {
+	return strings.ToLower(strings.TrimRight(strings.TrimSpace(z), "."))
+}
+
+// labelRelativeToZone returns the Joker label for name within zone. Names
+// may be relative ("www"), absolute ("www.example.com" with or without a
+// trailing dot) or the apex ("@", "" or the zone itself), which maps to "@".
+// The zone suffix is matched in punycode, so a Unicode name is found in a
+// punycode zone and the reverse; the label keeps the form it was given in.
+func labelRelativeToZone(name, zone string) string {
+	name = strings.TrimRight(strings.TrimSpace(name), ".")
+	zone = normalizeZone(zone)
+
+	if name == "" || name == "@" {
+		return "@"
+	}
+
+	aName, aZone := asciiOrSelf(name), asciiOrSelf(zone)
+	if strings.EqualFold(aName, aZone) {
+		return "@"
+	}
+
+	// If already relative (no zone suffix), keep it as-is.
+	// If it ends with ".<zone>", strip that suffix.
+	suffix := "." + aZone
+	if len(aName) <= len(suffix) || !strings.EqualFold(aName[len(aName)-len(suffix):], suffix) {
+		return name
+	}
+	keep := strings.Count(aName, ".") - strings.Count(aZone, ".") - 1
+	if labels := strings.Split(name, "."); len(labels) == strings.Count(aName, ".")+1 {
+		return strings.Join(labels[:keep+1], ".")
+	}
+	// Label separators changed in conversion; fall back to punycode
+	return aName[:len(aName)-len(suffix)]
+}
+
+// asciiOrSelf returns name in punycode, or name itself if it can't be
+// converted.
+func asciiOrSelf(name string) string {
+	if a, err := toASCII(name); err == nil {
+		return a
+	}
+	return name
+}
+
+// joinName is the inverse of labelRelativeToZone: the fully qualified
+// name (without trailing dot) of label in zone. The zone may have any
+// number of labels, as a delegated subzone such as "sub.example.com" has;
+// its suffix is added exactly once.
+func joinName(label, zone string) string {
+	zone = normalizeZone(zone)
+	label = labelRelativeToZone(label, zone)
+	if label == "@" {
+		return zone
+	}
+	return label + "." + zone
+}
+
+func (c *Client) logFormRedacted(form url.Values) {
+	c.baseLogger().Debug("joker request form", zap.Any("form", redactForm(form, c.FieldMap)))
+}
+
+// replaceFields are the /nic/replace form fields FieldMap can rename.
+var replaceFields = map[string]bool{
+	"username":  true,
+	"password":  true,
+	"api_token": true,
+	"zone":      true,
+	"label":     true,
+	"type":      true,
+	"ttl":       true,
+	"value":     true,
+}
+
+// mapFields returns form with its fields renamed according to FieldMap.
+func (c *Client) mapFields(form url.Values) url.Values {
+	if len(c.FieldMap) == 0 {
+		return form
+	}
+	mapped := url.Values{}
+	for k, v := range form {
+		if to, ok := c.FieldMap[k]; ok {
+			k = to
+		}
+		mapped[k] = v
+	}
+	return mapped
+}
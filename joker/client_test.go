@@ -0,0 +1,2060 @@
+package joker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/libdns/libdns"
+)
+
+func TestSetRecordsOverwritesTXT(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com",
+		`_acme-challenge TXT 0 "old" 300`,
+		`_acme-challenge TXT 0 "older" 300`,
+		"www A 0 192.0.2.1 300",
+	)
+	c := newTestClient(t, f)
+
+	set, err := c.SetRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", TTL: 5 * time.Minute, Text: "new"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", TTL: 5 * time.Minute, Text: "new"},
+	}, set)
+
+	assert.ElementsMatch(t, []string{
+		"www A 0 192.0.2.1 300",
+		`_acme-challenge TXT 0 "new" 300`,
+	}, f.zone("example.com"))
+}
+
+func TestCheckReplaceResponse(t *testing.T) {
+	tests := []struct {
+		body    string
+		wantErr bool
+		is      error
+	}{
+		{body: "OK"},
+		{body: "good 192.0.2.1"},
+		{body: "nochg 192.0.2.1", wantErr: true, is: ErrNoChange},
+		{body: "badauth", wantErr: true, is: ErrAuth},
+		{body: "notfqdn", wantErr: true},
+		{body: "nohost", wantErr: true, is: ErrNotFound},
+		{body: "numhost", wantErr: true},
+		{body: "abuse", wantErr: true},
+		{body: "badagent", wantErr: true},
+		{body: "dnserr", wantErr: true},
+		{body: "911", wantErr: true},
+		{body: "KO: Authentication error", wantErr: true, is: ErrAuth},
+		{body: "<html>maintenance</html>", wantErr: true},
+		{body: "GOOD"},
+		{body: " ok "},
+		{body: "Ok\r\n"},
+		{body: "NOCHG", wantErr: true, is: ErrNoChange},
+		{body: "okay", wantErr: true},
+		{body: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			err := checkReplaceResponse(tt.body)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			if tt.is != nil {
+				assert.ErrorIs(t, err, tt.is)
+			}
+		})
+	}
+}
+
+func TestAppendRecordsReportsBodyError(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "badauth")
+	}
+	c := newTestClient(t, f)
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	assert.ErrorIs(t, err, ErrAuth)
+}
+
+func TestAppendRecordsUnexpectedBody(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>maintenance</html>")
+	}
+	c := newTestClient(t, f)
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusOK, apiErr.StatusCode)
+	assert.ErrorContains(t, err, "unexpected response")
+}
+
+func TestRateLimit(t *testing.T) {
+	f := newFakeJoker(t)
+	c := newTestClient(t, f, func(c *Client) {
+		c.RateLimit = 20
+	})
+
+	const calls = 5
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := c.postForm(context.Background(), c.DMAPIEndpoint+"/login", url.Values{})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// The first request goes at once, each further one waits 1/20s
+	assert.GreaterOrEqual(t, time.Since(start), (calls-1)*time.Second/20)
+	assert.Len(t, f.received(dmapiPath+"/login"), calls)
+}
+
+func TestRateLimitHonorsContext(t *testing.T) {
+	f := newFakeJoker(t)
+	c := newTestClient(t, f, func(c *Client) {
+		c.RateLimit = 0.001
+	})
+
+	_, _, err := c.postForm(context.Background(), c.DMAPIEndpoint+"/login", url.Values{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = c.postForm(ctx, c.DMAPIEndpoint+"/login", url.Values{})
+	// The limiter fails at once when the wait would outlast the deadline
+	assert.ErrorContains(t, err, "deadline")
+	assert.Len(t, f.received(dmapiPath+"/login"), 1)
+}
+
+func TestUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{name: "default", want: "caddy-dns-joker/"},
+		{name: "override", userAgent: "my-tool/1.0", want: "my-tool/1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			c := newTestClient(t, f, func(c *Client) {
+				c.UserAgent = tt.userAgent
+			})
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			require.NoError(t, err)
+
+			for _, path := range []string{nicReplacePath, dmapiPath + "/login"} {
+				reqs := f.received(path)
+				require.NotEmpty(t, reqs)
+				assert.True(t, strings.HasPrefix(reqs[0].Header.Get("User-Agent"), tt.want),
+					"%s User-Agent %q", path, reqs[0].Header.Get("User-Agent"))
+			}
+		})
+	}
+}
+
+func TestLabelRelativeToZone(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "_acme-challenge", want: "_acme-challenge"},
+		{name: "_acme-challenge.example.com", want: "_acme-challenge"},
+		{name: "a.b.example.com", want: "a.b"},
+		{name: "@", want: "@"},
+		{name: "", want: "@"},
+		{name: "example.com", want: "@"},
+		{name: "EXAMPLE.com", want: "@"},
+		{name: "notexample.com", want: "notexample.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, labelRelativeToZone(tt.name, "example.com"))
+		})
+	}
+
+	assert.Equal(t, "www", labelRelativeToZone("www.xn--mnchen-3ya.de", "münchen.de"))
+	assert.Equal(t, "www", labelRelativeToZone("www.münchen.de.", "xn--mnchen-3ya.de"))
+	assert.Equal(t, "bücher", labelRelativeToZone("bücher.xn--mnchen-3ya.de", "münchen.de"))
+	assert.Equal(t, "@", labelRelativeToZone("xn--mnchen-3ya.de", "münchen.de"))
+}
+
+func TestJoinName(t *testing.T) {
+	assert.Equal(t, "www.example.com", joinName("www", "example.com"))
+	assert.Equal(t, "www.example.com", joinName("www.example.com", "example.com"))
+	assert.Equal(t, "example.com", joinName("@", "example.com"))
+	assert.Equal(t, "www.sub.example.com", joinName("www", "sub.example.com"))
+	assert.Equal(t, "www.sub.example.com", joinName("www.sub.example.com.", "Sub.Example.com."))
+	assert.Equal(t, "sub.example.com", joinName("sub.example.com", "sub.example.com"))
+}
+
+func TestDelegatedSubzone(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("sub.example.com")
+	c := newTestClient(t, f)
+
+	added, err := c.AppendRecords(context.Background(), "sub.example.com.", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", TTL: 5 * time.Minute, Text: "relative"},
+		libdns.TXT{Name: "_acme-challenge.www.sub.example.com.", TTL: 5 * time.Minute, Text: "absolute"},
+		libdns.Address{Name: "@", TTL: 5 * time.Minute, IP: netip.MustParseAddr("192.0.2.1")},
+	})
+	require.NoError(t, err)
+	assert.Len(t, added, 3)
+
+	for _, r := range f.received(nicReplacePath) {
+		assert.Equal(t, "sub.example.com", r.Form.Get("zone"))
+		assert.NotContains(t, r.Form.Get("label"), "example.com")
+	}
+	assert.ElementsMatch(t, []string{
+		`_acme-challenge TXT 0 "relative" 300`,
+		`_acme-challenge.www TXT 0 "absolute" 300`,
+		"@ A 0 192.0.2.1 300",
+	}, f.zone("sub.example.com"))
+
+	deleted, err := c.DeleteRecords(context.Background(), "sub.example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge.sub.example.com.", Text: "relative"},
+		libdns.TXT{Name: "_acme-challenge.www", Text: "absolute"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, deleted, 2)
+	assert.Equal(t, []string{"@ A 0 192.0.2.1 300"}, f.zone("sub.example.com"))
+}
+
+func TestAppendRecordsNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		wantLabel string
+	}{
+		{name: "_acme-challenge", wantLabel: "_acme-challenge"},
+		{name: "_acme-challenge.example.com", wantLabel: "_acme-challenge"},
+		{name: "@", wantLabel: "@"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			c := newTestClient(t, f)
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: tt.name, Text: "token"},
+			})
+			require.NoError(t, err)
+
+			reqs := f.received(nicReplacePath)
+			require.Len(t, reqs, 1)
+			assert.Equal(t, "example.com", reqs[0].Form.Get("zone"))
+			assert.Equal(t, tt.wantLabel, reqs[0].Form.Get("label"))
+		})
+	}
+}
+
+func TestTrailingDots(t *testing.T) {
+	type call struct {
+		zone, name string
+	}
+	ops := []struct {
+		name string
+		run  func(c *Client, in call) error
+	}{
+		{name: "append", run: func(c *Client, in call) error {
+			_, err := c.AppendRecords(context.Background(), in.zone, []libdns.Record{
+				libdns.TXT{Name: in.name, Text: "token"},
+			})
+			return err
+		}},
+		{name: "set", run: func(c *Client, in call) error {
+			_, err := c.SetRecords(context.Background(), in.zone, []libdns.Record{
+				libdns.TXT{Name: in.name, Text: "token"},
+			})
+			return err
+		}},
+		{name: "delete", run: func(c *Client, in call) error {
+			_, err := c.DeleteRecords(context.Background(), in.zone, []libdns.Record{
+				libdns.TXT{Name: in.name, Text: "old"},
+			})
+			return err
+		}},
+	}
+	calls := []call{
+		{zone: "example.com", name: "sub"},
+		{zone: "example.com.", name: "sub"},
+		{zone: "example.com.", name: "sub.example.com."},
+		{zone: "example.com", name: "sub.example.com."},
+	}
+
+	for _, op := range ops {
+		t.Run(op.name, func(t *testing.T) {
+			var forms []url.Values
+			for _, in := range calls {
+				f := newFakeJoker(t)
+				f.setZone("example.com", `sub TXT 0 "old" 300`)
+				c := newTestClient(t, f)
+
+				require.NoError(t, op.run(c, in), "%+v", in)
+				reqs := f.received(nicReplacePath)
+				require.Len(t, reqs, 1, "%+v", in)
+				forms = append(forms, reqs[0].Form)
+			}
+
+			assert.Equal(t, "example.com", forms[0].Get("zone"))
+			assert.Equal(t, "sub", forms[0].Get("label"))
+			for _, form := range forms[1:] {
+				assert.Equal(t, forms[0], form)
+			}
+		})
+	}
+
+	t.Run("get", func(t *testing.T) {
+		f := newFakeJoker(t)
+		f.setZone("example.com", `sub TXT 0 "old" 300`)
+		c := newTestClient(t, f)
+
+		want, err := c.GetRecords(context.Background(), "example.com")
+		require.NoError(t, err)
+		got, err := c.GetRecords(context.Background(), "example.com.")
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+}
+
+func TestNormalizeTXT(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "plain", want: "plain"},
+		{in: `"quoted"`, want: "quoted"},
+		{in: `"abc" "def"`, want: "abcdef"},
+		{in: `"say \"hi\""`, want: `say "hi"`},
+		{in: `a "b" c`, want: `a "b" c`},
+		{in: `"`, want: `"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			assert.Equal(t, tt.want, normalizeTXT(tt.in))
+		})
+	}
+}
+
+func TestRRSetValuesOnlyUnquotesTXT(t *testing.T) {
+	c := &Client{}
+
+	assert.Equal(t, []string{`"target".example.net`}, c.rrsetValues("CNAME", []libdns.Record{
+		libdns.RR{Name: "www", Type: "CNAME", Data: `"target".example.net`},
+	}))
+	assert.Equal(t, []string{"v=spf1 -all", "abcdef"}, c.rrsetValues("TXT", []libdns.Record{
+		libdns.RR{Name: "@", Type: "TXT", Data: `"v=spf1 -all"`},
+		libdns.RR{Name: "@", Type: "TXT", Data: `"abc" "def"`},
+	}))
+}
+
+func TestChunkTXT(t *testing.T) {
+	dkim := "v=DKIM1; k=rsa; p=" + strings.Repeat("A", 282)
+	require.Len(t, dkim, 300)
+
+	chunked := chunkTXT(dkim)
+	assert.Equal(t, `"`+dkim[:255]+`" "`+dkim[255:]+`"`, chunked)
+	assert.Equal(t, dkim, normalizeTXT(chunked))
+
+	assert.Equal(t, "short", chunkTXT("short"))
+	assert.Equal(t, strings.Repeat("x", 255), chunkTXT(strings.Repeat("x", 255)))
+}
+
+func TestAppendLongTXT(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	dkim := "v=DKIM1; k=rsa; p=" + strings.Repeat("A", 282)
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "sel._domainkey", Text: dkim},
+	})
+	require.NoError(t, err)
+
+	reqs := f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, `"`+dkim[:255]+`" "`+dkim[255:]+`"`, reqs[0].Form.Get("value"))
+
+	records, err := c.GetRecords(context.Background(), "example.com")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, dkim, records[0].(libdns.TXT).Text)
+}
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "münchen.de", want: "xn--mnchen-3ya.de"},
+		{in: "xn--mnchen-3ya.de", want: "xn--mnchen-3ya.de"},
+		{in: "www.münchen.de", want: "www.xn--mnchen-3ya.de"},
+		{in: "*.münchen.de", want: "*.xn--mnchen-3ya.de"},
+		{in: "_acme-challenge", want: "_acme-challenge"},
+		{in: "@", want: "@"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := toASCII(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.Equal(t, "www.münchen.de", toUnicode("www.xn--mnchen-3ya.de"))
+}
+
+func TestUnicodeZone(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("xn--mnchen-3ya.de")
+	c := newTestClient(t, f)
+
+	_, err := c.AppendRecords(context.Background(), "münchen.de", []libdns.Record{
+		libdns.TXT{Name: "bücher", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	reqs := f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "xn--mnchen-3ya.de", reqs[0].Form.Get("zone"))
+	assert.Equal(t, "xn--bcher-kva", reqs[0].Form.Get("label"))
+
+	records, err := c.GetRecords(context.Background(), "münchen.de")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "bücher", records[0].RR().Name)
+}
+
+func TestMixedFormZone(t *testing.T) {
+	tests := []struct {
+		zone string
+		name string
+	}{
+		{zone: "münchen.de", name: "www.xn--mnchen-3ya.de"},
+		{zone: "münchen.de", name: "www.xn--mnchen-3ya.de."},
+		{zone: "xn--mnchen-3ya.de", name: "www.münchen.de"},
+		{zone: "xn--mnchen-3ya.de", name: "www.münchen.de."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.zone+" "+tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("xn--mnchen-3ya.de")
+			c := newTestClient(t, f)
+
+			_, err := c.AppendRecords(context.Background(), tt.zone, []libdns.Record{
+				libdns.TXT{Name: tt.name, Text: "token"},
+			})
+			require.NoError(t, err)
+
+			reqs := f.received(nicReplacePath)
+			require.Len(t, reqs, 1)
+			assert.Equal(t, "xn--mnchen-3ya.de", reqs[0].Form.Get("zone"))
+			assert.Equal(t, "www", reqs[0].Form.Get("label"))
+		})
+	}
+}
+
+func TestLogsEachRecord(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	core, logs := observer.New(zapcore.DebugLevel)
+	c := newTestClient(t, f, func(c *Client) {
+		c.logger = zap.New(core)
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "one", Text: "token"},
+		libdns.Address{Name: "two", IP: netip.MustParseAddr("192.0.2.1")},
+	})
+	require.NoError(t, err)
+
+	replaces := logs.FilterMessage("joker replace").All()
+	require.Len(t, replaces, 2)
+	var labels []string
+	for _, entry := range replaces {
+		assert.Equal(t, zapcore.DebugLevel, entry.Level)
+		fields := entry.ContextMap()
+		labels = append(labels, fields["label"].(string))
+		assert.Contains(t, fields, "type")
+		assert.Contains(t, fields, "ttl")
+		assert.Equal(t, f.srv.URL+nicReplacePath, fields["endpoint"])
+		assert.NotEmpty(t, fields["request_id"])
+	}
+	assert.ElementsMatch(t, []string{"one", "two"}, labels)
+}
+
+func TestLogsEachEndpointTried(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+	}))
+	t.Cleanup(primary.Close)
+	core, logs := observer.New(zapcore.DebugLevel)
+	c := newTestClient(t, f, func(c *Client) {
+		c.Endpoint = ""
+		c.Endpoints = []string{primary.URL + nicReplacePath, f.srv.URL + nicReplacePath}
+		c.logger = zap.New(core)
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "one", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	var endpoints []string
+	for _, entry := range logs.FilterMessage("joker replace").All() {
+		fields := entry.ContextMap()
+		assert.Equal(t, "one", fields["label"])
+		endpoints = append(endpoints, fields["endpoint"].(string))
+	}
+	assert.Equal(t, []string{primary.URL + nicReplacePath, f.srv.URL + nicReplacePath}, endpoints)
+}
+
+func TestLogsFailureAtWarn(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadRequest)
+	}
+	core, logs := observer.New(zapcore.WarnLevel)
+	c := newTestClient(t, f, func(c *Client) {
+		c.logger = zap.New(core)
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "one", Text: "token"},
+	})
+	require.Error(t, err)
+
+	failures := logs.FilterMessage("joker API error").All()
+	require.Len(t, failures, 1)
+	assert.Equal(t, "one", failures[0].ContextMap()["label"])
+	assert.Zero(t, logs.FilterMessage("joker replace").Len(), "debug entries must respect the level")
+}
+
+func TestAppendRecordsConcurrency(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	var inflight, maxInflight atomic.Int32
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			m := maxInflight.Load()
+			if n <= m || maxInflight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, "OK")
+	}
+	c := newTestClient(t, f, func(c *Client) {
+		c.Concurrency = 4
+	})
+
+	var records []libdns.Record
+	for i := range 10 {
+		records = append(records, libdns.TXT{Name: fmt.Sprintf("r%d", i), Text: "token"})
+	}
+	added, err := c.AppendRecords(context.Background(), "example.com", records)
+	require.NoError(t, err)
+
+	assert.Len(t, added, 10)
+	assert.Len(t, f.received(nicReplacePath), 10)
+	assert.LessOrEqual(t, maxInflight.Load(), int32(4))
+	assert.Greater(t, maxInflight.Load(), int32(1))
+}
+
+func TestAppendRecordsReturnsOnlySuccesses(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		if r.Form.Get("label") == "bad" {
+			http.Error(w, "nope", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "OK")
+	}
+	c := newTestClient(t, f, func(c *Client) {
+		c.ContinueOnError = true
+	})
+
+	added, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "one", Text: "token"},
+		libdns.TXT{Name: "bad", Text: "token"},
+		libdns.TXT{Name: "two", Text: "token"},
+	})
+	require.Error(t, err)
+
+	var names []string
+	for _, rec := range added {
+		names = append(names, rec.RR().Name)
+	}
+	assert.ElementsMatch(t, []string{"one", "two"}, names)
+}
+
+func TestAppendRecordsRollback(t *testing.T) {
+	f := newFakeJoker(t)
+	var writtenFirst atomic.Int32
+	replace := f.serveReplace
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		if r.Form.Get("label") == "bad" {
+			if len(f.received(nicReplacePath)) == 3 {
+				writtenFirst.Add(1)
+			}
+			http.Error(w, "nope", http.StatusBadRequest)
+			return
+		}
+		replace(w, r.Form)
+	}
+	c := newTestClient(t, f, func(c *Client) {
+		c.RollbackOnError = true
+		c.Concurrency = 1
+	})
+
+	// Record sets are written in no particular order; repeat until the
+	// failing one came last at least once. Whatever was written before it
+	// must be undone: first deleted again, kept given back its old value
+	// and TTL.
+	for writtenFirst.Load() == 0 {
+		f.forget()
+		f.setZone("example.com", `kept TXT 0 "old" 60`)
+
+		_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+			libdns.TXT{Name: "first", Text: "token"},
+			libdns.TXT{Name: "bad", Text: "token"},
+			libdns.TXT{Name: "kept", Text: "new"},
+		})
+		require.Error(t, err)
+		require.Equal(t, []string{`kept TXT 0 "old" 60`}, f.zone("example.com"))
+	}
+}
+
+func TestAPIKeyOnly(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f, func(c *Client) {
+		c.APIToken = ""
+		c.APIKey = "key-123"
+	})
+	require.NoError(t, c.Validate())
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	logins := f.received(dmapiPath + "/login")
+	require.Len(t, logins, 1)
+	assert.Equal(t, "key-123", logins[0].Form.Get("api-key"))
+	assert.Empty(t, logins[0].Form.Get("password"))
+
+	replaces := f.received(nicReplacePath)
+	require.Len(t, replaces, 1)
+	assert.Equal(t, "key-123", replaces[0].Form.Get("api_token"))
+	assert.Empty(t, replaces[0].Form.Get("username"))
+}
+
+func TestAPIKeyWithPassword(t *testing.T) {
+	c := &Client{APIKey: "key-123", Username: "alice", Password: "s3cret"}
+	require.NoError(t, c.setup())
+	assert.ErrorContains(t, c.Validate(), "not both")
+}
+
+func TestUnsupportedTypeSendsNothing(t *testing.T) {
+	f := newFakeJoker(t)
+	c := newTestClient(t, f)
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.RR{Name: "www", Type: "FOO", Data: "bar"},
+	})
+	assert.ErrorContains(t, err, `unsupported record type "FOO"`)
+	_, err = c.SetRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.RR{Name: "www", Type: "FOO", Data: "bar"},
+	})
+	assert.ErrorContains(t, err, `unsupported record type "FOO"`)
+	_, err = c.DeleteRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.RR{Name: "www", Type: "FOO", Data: "bar"},
+	})
+	assert.ErrorContains(t, err, `unsupported record type "FOO"`)
+
+	assert.Empty(t, f.received(nicReplacePath))
+	assert.Empty(t, f.received(dmapiPath+"/login"))
+}
+
+func TestCheckAddresses(t *testing.T) {
+	tests := []struct {
+		rtype, data string
+		wantErr     string
+	}{
+		{rtype: "A", data: "192.0.2.1"},
+		{rtype: "AAAA", data: "2001:db8::1"},
+		{rtype: "A", data: "2001:db8::1", wantErr: "use an AAAA record"},
+		{rtype: "AAAA", data: "192.0.2.1", wantErr: "use an A record"},
+		{rtype: "AAAA", data: "::ffff:192.0.2.1", wantErr: "use an A record"},
+		{rtype: "A", data: "192.0.2", wantErr: "invalid IP address"},
+		{rtype: "TXT", data: "not an address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rtype+" "+tt.data, func(t *testing.T) {
+			err := checkAddresses([]libdns.Record{libdns.RR{Name: "www", Type: tt.rtype, Data: tt.data}})
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSwappedAddressFamilySendsNothing(t *testing.T) {
+	f := newFakeJoker(t)
+	c := newTestClient(t, f)
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.RR{Name: "www", Type: "A", Data: "2001:db8::1"},
+	})
+	require.Error(t, err)
+	assert.Empty(t, f.received(nicReplacePath))
+}
+
+func TestZeroTTLUsesDefault(t *testing.T) {
+	tests := []struct {
+		name       string
+		defaultTTL Duration
+		want       string
+	}{
+		{name: "built-in default", want: "3600"},
+		{name: "configured default", defaultTTL: Duration(10 * time.Minute), want: "600"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			c := newTestClient(t, f, func(c *Client) {
+				c.DefaultTTL = tt.defaultTTL
+			})
+
+			added, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			require.NoError(t, err)
+
+			reqs := f.received(nicReplacePath)
+			require.Len(t, reqs, 1)
+			assert.Equal(t, tt.want, reqs[0].Form.Get("ttl"))
+			require.Len(t, added, 1)
+			assert.Equal(t, tt.want, strconv.Itoa(int(added[0].RR().TTL.Seconds())))
+		})
+	}
+}
+
+func TestClampTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      int
+		min, max Duration
+		want     int
+	}{
+		{name: "below minimum", ttl: 30, want: 60},
+		{name: "in range", ttl: 300, want: 300},
+		{name: "above maximum", ttl: 172800, want: 86400},
+		{name: "configured minimum", ttl: 300, min: Duration(10 * time.Minute), want: 600},
+		{name: "configured maximum", ttl: 7200, max: Duration(time.Hour), want: 3600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.WarnLevel)
+			c := &Client{MinTTL: tt.min, MaxTTL: tt.max, logger: zap.New(core)}
+
+			assert.Equal(t, tt.want, c.clampTTL(tt.ttl, "example.com", "www", "A"))
+			if tt.want != tt.ttl {
+				assert.Equal(t, 1, logs.FilterMessage("TTL out of range, clamping").Len())
+			} else {
+				assert.Zero(t, logs.Len())
+			}
+		})
+	}
+}
+
+func TestSetRecordsClampsTTL(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	_, err := c.SetRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", TTL: 10 * time.Second, Text: "token"},
+	})
+	require.NoError(t, err)
+
+	reqs := f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "60", reqs[0].Form.Get("ttl"))
+}
+
+func TestAppendRecordsDeduplicates(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	token := libdns.TXT{Name: "_acme-challenge", TTL: 300 * time.Second, Text: "token"}
+	added, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		token,
+		token,
+		libdns.TXT{Name: "_acme-challenge", TTL: 300 * time.Second, Text: "other"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, added, 3)
+
+	reqs := f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, `token,other`, reqs[0].Form.Get("value"))
+	assert.ElementsMatch(t, []string{
+		`_acme-challenge TXT 0 "token" 300`,
+		`_acme-challenge TXT 0 "other" 300`,
+	}, f.zone("example.com"))
+}
+
+func TestAppendRecordsKeepsExistingValues(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com", `www A 0 192.0.2.1 300`)
+	c := newTestClient(t, f)
+
+	for _, text := range []string{"wildcard-token", "base-token", "base-token"} {
+		_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+			libdns.TXT{Name: "_acme-challenge", TTL: 300 * time.Second, Text: text},
+		})
+		require.NoError(t, err)
+	}
+
+	assert.ElementsMatch(t, []string{
+		`www A 0 192.0.2.1 300`,
+		`_acme-challenge TXT 0 "wildcard-token" 300`,
+		`_acme-challenge TXT 0 "base-token" 300`,
+	}, f.zone("example.com"))
+
+	recs, err := c.GetRecords(context.Background(), "example.com")
+	require.NoError(t, err)
+	var texts []string
+	for _, rec := range recs {
+		if txt, ok := rec.(libdns.TXT); ok {
+			texts = append(texts, txt.Text)
+		}
+	}
+	assert.ElementsMatch(t, []string{"wildcard-token", "base-token"}, texts)
+}
+
+func TestDeleteRecordsKeepsOtherValues(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com",
+		`_acme-challenge TXT 0 "wildcard-token" 300`,
+		`_acme-challenge TXT 0 "base-token" 300`,
+	)
+	c := newTestClient(t, f)
+
+	deleted, err := c.DeleteRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "wildcard-token"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, deleted, 1)
+	assert.Equal(t, []string{`_acme-challenge TXT 0 "base-token" 300`}, f.zone("example.com"))
+
+	reqs := f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "base-token", reqs[0].Form.Get("value"))
+	assert.Equal(t, "300", reqs[0].Form.Get("ttl"))
+}
+
+func TestDeleteRecordsEmptyDataDeletesAll(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com",
+		`_acme-challenge TXT 0 "wildcard-token" 300`,
+		`_acme-challenge TXT 0 "base-token" 300`,
+		`www A 0 192.0.2.1 300`,
+	)
+	c := newTestClient(t, f)
+
+	_, err := c.DeleteRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.RR{Name: "_acme-challenge", Type: "TXT"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`www A 0 192.0.2.1 300`}, f.zone("example.com"))
+}
+
+func TestAppendRecordsReturnsStoredRecords(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	added, err := c.AppendRecords(context.Background(), "example.com.", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge.example.com.", TTL: 10 * time.Second, Text: "token"},
+		libdns.Address{Name: "www", TTL: 200 * 24 * time.Hour, IP: netip.MustParseAddr("192.0.2.1")},
+	})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", TTL: 60 * time.Second, Text: "token"},
+		libdns.Address{Name: "www", TTL: 86400 * time.Second, IP: netip.MustParseAddr("192.0.2.1")},
+	}, added)
+}
+
+func TestDryRunSendsNoWrites(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com", `_acme-challenge TXT 0 "old-token" 300`)
+	core, logs := observer.New(zap.InfoLevel)
+	c := newTestClient(t, f, func(c *Client) {
+		c.DryRun = true
+		c.logger = zap.New(core)
+	})
+
+	added, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, added, 1)
+
+	deleted, err := c.DeleteRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "old-token"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, deleted, 1)
+
+	assert.Empty(t, f.received(nicReplacePath))
+	assert.Empty(t, f.received(dmapiPath+"/dns-zone-put"))
+	assert.NotEmpty(t, f.received(dmapiPath+"/login"))
+	assert.Equal(t, []string{`_acme-challenge TXT 0 "old-token" 300`}, f.zone("example.com"))
+	assert.Equal(t, 2, logs.FilterMessage("dry run: not replacing DNS record").Len())
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+		wantErr  string
+	}{
+		{name: "default", want: defaultEndpoint},
+		{name: "valid", endpoint: "https://joker.example.net/nic/replace", want: "https://joker.example.net/nic/replace"},
+		{name: "trailing slashes", endpoint: "https://joker.example.net/nic/replace//", want: "https://joker.example.net/nic/replace"},
+		{name: "plain http", endpoint: "http://127.0.0.1:8080/nic/replace", want: "http://127.0.0.1:8080/nic/replace"},
+		{name: "no scheme", endpoint: "svc.joker.com/nic/replace", wantErr: "scheme must be http or https"},
+		{name: "other scheme", endpoint: "ftp://svc.joker.com/nic/replace", wantErr: "scheme must be http or https"},
+		{name: "no host", endpoint: "https:///nic/replace", wantErr: "must be an absolute URL with a host"},
+		{name: "unparseable", endpoint: "https://svc.joker.com/%zz", wantErr: `invalid endpoint "https://svc.joker.com/%zz"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{APIToken: "secret-token", Endpoint: tt.endpoint}
+			require.NoError(t, c.setup())
+
+			err := c.Validate()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, c.Endpoint)
+		})
+	}
+}
+
+func TestHTTPMethod(t *testing.T) {
+	const password = "p&ss=w rd+%"
+
+	tests := []struct {
+		name   string
+		method string
+		want   string
+	}{
+		{name: "default", want: http.MethodPost},
+		{name: "get", method: "get", want: http.MethodGet},
+		{name: "post", method: http.MethodPost, want: http.MethodPost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			var query, body url.Values
+			f.replace = func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query()
+				body = r.PostForm
+				f.serveReplace(w, r.Form)
+			}
+			c := newTestClient(t, f, func(c *Client) {
+				c.APIToken = ""
+				c.Username = "alice"
+				c.Password = password
+				c.HTTPMethod = tt.method
+			})
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			require.NoError(t, err)
+
+			reqs := f.received(nicReplacePath)
+			require.Len(t, reqs, 1)
+			assert.Equal(t, tt.want, reqs[0].Method)
+
+			sent, empty := body, query
+			if tt.want == http.MethodGet {
+				sent, empty = query, body
+			}
+			assert.Equal(t, password, sent.Get("password"))
+			assert.Equal(t, "alice", sent.Get("username"))
+			assert.Empty(t, empty)
+		})
+	}
+}
+
+func TestWildcardRecords(t *testing.T) {
+	tests := []struct {
+		name      string
+		wantLabel string
+	}{
+		{name: "*", wantLabel: "*"},
+		{name: "*.sub", wantLabel: "*.sub"},
+		{name: "*.example.com", wantLabel: "*"},
+		{name: "*.sub.example.com.", wantLabel: "*.sub"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			c := newTestClient(t, f)
+
+			added, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.Address{Name: tt.name, IP: netip.MustParseAddr("192.0.2.1")},
+			})
+			require.NoError(t, err)
+			require.Len(t, added, 1)
+			assert.Equal(t, tt.wantLabel, added[0].RR().Name)
+
+			reqs := f.received(nicReplacePath)
+			require.Len(t, reqs, 1)
+			assert.Equal(t, tt.wantLabel, reqs[0].Form.Get("label"))
+
+			recs, err := c.GetRecords(context.Background(), "example.com")
+			require.NoError(t, err)
+			assert.Equal(t, []libdns.Record{
+				libdns.Address{Name: tt.wantLabel, TTL: time.Hour, IP: netip.MustParseAddr("192.0.2.1")},
+			}, recs)
+		})
+	}
+}
+
+func TestApexRecords(t *testing.T) {
+	for _, name := range []string{"@", "", "example.com", "example.com."} {
+		t.Run(name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			c := newTestClient(t, f)
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.Address{Name: name, IP: netip.MustParseAddr("192.0.2.1")},
+				libdns.TXT{Name: name, Text: "v=spf1 -all"},
+			})
+			require.NoError(t, err)
+
+			reqs := f.received(nicReplacePath)
+			require.Len(t, reqs, 2)
+			for _, r := range reqs {
+				assert.Equal(t, "example.com", r.Form.Get("zone"))
+				assert.Equal(t, "@", r.Form.Get("label"))
+			}
+
+			recs, err := c.GetRecords(context.Background(), "example.com")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []libdns.Record{
+				libdns.Address{Name: "@", TTL: time.Hour, IP: netip.MustParseAddr("192.0.2.1")},
+				libdns.TXT{Name: "@", TTL: time.Hour, Text: "v=spf1 -all"},
+			}, recs)
+		})
+	}
+}
+
+func TestCancelStopsBetweenRecords(t *testing.T) {
+	records := []libdns.Record{
+		libdns.TXT{Name: "a", Text: "token"},
+		libdns.TXT{Name: "b", Text: "token"},
+		libdns.TXT{Name: "c", Text: "token"},
+	}
+	tests := []struct {
+		name  string
+		lines []string
+		run   func(ctx context.Context, c *Client) ([]libdns.Record, error)
+	}{
+		{
+			name: "append",
+			run: func(ctx context.Context, c *Client) ([]libdns.Record, error) {
+				return c.AppendRecords(ctx, "example.com", records)
+			},
+		},
+		{
+			name:  "delete",
+			lines: []string{`a TXT 0 "token" 300`, `b TXT 0 "token" 300`, `c TXT 0 "token" 300`},
+			run: func(ctx context.Context, c *Client) ([]libdns.Record, error) {
+				return c.DeleteRecords(ctx, "example.com", records)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com", tt.lines...)
+
+			// Cancel once the first record's write has completed
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			c := newTestClient(t, f, func(c *Client) {
+				c.Concurrency = 1
+				c.trace = &Trace{OnResponse: func(_, endpoint string, _ int, _ string) {
+					if endpoint == c.Endpoint {
+						cancel()
+					}
+				}}
+			})
+
+			done, err := tt.run(ctx, c)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.Len(t, done, 1)
+			assert.Len(t, f.received(nicReplacePath), 1)
+		})
+	}
+}
+
+func TestNoChange(t *testing.T) {
+	tests := []struct {
+		name           string
+		reportNoChange bool
+		wantErr        error
+	}{
+		{name: "default"},
+		{name: "reported", reportNoChange: true, wantErr: ErrNoChange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			f.replace = func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "nochg 192.0.2.1")
+			}
+			c := newTestClient(t, f, func(c *Client) {
+				c.ReportNoChange = tt.reportNoChange
+			})
+			records := []libdns.Record{
+				libdns.Address{Name: "www", IP: netip.MustParseAddr("192.0.2.1")},
+			}
+
+			results, err := c.AppendRecordsDetailed(context.Background(), "example.com", records)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			require.Len(t, results, 1)
+			assert.NoError(t, results[0].Err)
+			assert.False(t, results[0].Created)
+
+			added, err := c.AppendRecords(context.Background(), "example.com", records)
+			assert.Len(t, added, 1)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSetRecordsWritesOnlyChanges(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com",
+		"www A 0 192.0.2.1 300",
+		`@ TXT 0 "v=spf1 -all" 300`,
+	)
+	c := newTestClient(t, f)
+
+	set, err := c.SetRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.Address{Name: "www", TTL: 300 * time.Second, IP: netip.MustParseAddr("192.0.2.1")},
+		libdns.TXT{Name: "@", TTL: 300 * time.Second, Text: "v=spf1 -all"},
+		libdns.Address{Name: "mail", TTL: 300 * time.Second, IP: netip.MustParseAddr("192.0.2.2")},
+	})
+	require.NoError(t, err)
+	assert.Len(t, set, 3)
+
+	reqs := f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "mail", reqs[0].Form.Get("label"))
+
+	// A changed TTL is a change too
+	f.forget()
+	_, err = c.SetRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.Address{Name: "www", TTL: 600 * time.Second, IP: netip.MustParseAddr("192.0.2.1")},
+	})
+	require.NoError(t, err)
+	reqs = f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "600", reqs[0].Form.Get("ttl"))
+}
+
+func TestForceTTL(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		defaultTTL Duration
+		forceTTL   Duration
+		want       string
+	}{
+		{name: "record TTL without force", ttl: 5 * time.Minute, want: "300"},
+		{name: "overrides record TTL", ttl: 5 * time.Minute, forceTTL: Duration(2 * time.Minute), want: "120"},
+		{name: "overrides default TTL", defaultTTL: Duration(10 * time.Minute), forceTTL: Duration(2 * time.Minute), want: "120"},
+		{name: "still clamped", ttl: 5 * time.Minute, forceTTL: Duration(time.Second), want: "60"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			c := newTestClient(t, f, func(c *Client) {
+				c.DefaultTTL = tt.defaultTTL
+				c.ForceTTL = tt.forceTTL
+			})
+
+			added, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", TTL: tt.ttl, Text: "token"},
+			})
+			require.NoError(t, err)
+
+			reqs := f.received(nicReplacePath)
+			require.Len(t, reqs, 1)
+			assert.Equal(t, tt.want, reqs[0].Form.Get("ttl"))
+			require.Len(t, added, 1)
+			assert.Equal(t, tt.want, strconv.Itoa(int(added[0].RR().TTL.Seconds())))
+		})
+	}
+}
+
+func TestPTRUnsupported(t *testing.T) {
+	f := newFakeJoker(t)
+	c := newTestClient(t, f)
+
+	_, err := c.AppendRecords(context.Background(), "2.0.192.in-addr.arpa", []libdns.Record{
+		libdns.RR{Name: "1", Type: "PTR", Data: "host.example.com."},
+	})
+	assert.ErrorContains(t, err, `unsupported record type "PTR" for "1"`)
+	assert.ErrorContains(t, err, "reverse (in-addr.arpa/ip6.arpa) zones")
+
+	assert.Empty(t, f.received(nicReplacePath))
+	assert.Empty(t, f.received(dmapiPath+"/login"))
+	assert.NotContains(t, c.SupportedRecordTypes(), "PTR")
+}
+
+func TestUnsupportedTypeReasons(t *testing.T) {
+	tests := []struct {
+		rtype string
+		want  string
+	}{
+		{rtype: "ALIAS", want: "no ALIAS/ANAME record type"},
+		{rtype: "ANAME", want: "no ALIAS/ANAME record type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rtype, func(t *testing.T) {
+			f := newFakeJoker(t)
+			c := newTestClient(t, f)
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.RR{Name: "@", Type: tt.rtype, Data: "target.example.net."},
+			})
+			assert.ErrorContains(t, err, `unsupported record type "`+tt.rtype+`"`)
+			assert.ErrorContains(t, err, tt.want)
+			assert.Empty(t, f.received(nicReplacePath))
+			assert.Empty(t, f.received(dmapiPath+"/login"))
+		})
+	}
+}
+
+func TestServiceBindingUnsupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme string
+		rtype  string
+	}{
+		{name: "https", scheme: "https", rtype: "HTTPS"},
+		{name: "svcb", scheme: "dns", rtype: "SVCB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			c := newTestClient(t, f)
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.ServiceBinding{
+					Scheme:   tt.scheme,
+					Name:     "@",
+					Priority: 1,
+					Target:   ".",
+					Params: libdns.SvcParams{
+						"alpn": {"h2", "h3"},
+						"ech":  {"AEn+DQBC"},
+					},
+				},
+			})
+			assert.ErrorContains(t, err, `unsupported record type "`+tt.rtype+`"`)
+			assert.ErrorContains(t, err, "no HTTPS/SVCB (RFC 9460) record type")
+			assert.Empty(t, f.received(nicReplacePath))
+			assert.Empty(t, f.received(dmapiPath+"/dns-zone-put"))
+		})
+	}
+}
+
+func TestNameOutsideZone(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "www.example.org.", wantErr: true},
+		{name: "notexample.com.", wantErr: true},
+		{name: "example.com.", wantErr: false},
+		{name: "WWW.Example.COM.", wantErr: false},
+		{name: "www.example.org", wantErr: false}, // relative: www.example.org.example.com
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNames("example.com", []libdns.Record{
+				libdns.TXT{Name: tt.name, Text: "token"},
+			})
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, `record name "`+tt.name+`" is not within zone "example.com"`)
+		})
+	}
+
+	assert.NoError(t, checkNames("münchen.de", []libdns.Record{libdns.TXT{Name: "www.xn--mnchen-3ya.de.", Text: "token"}}))
+	assert.NoError(t, checkNames("xn--mnchen-3ya.de", []libdns.Record{libdns.TXT{Name: "www.münchen.de.", Text: "token"}}))
+	assert.Error(t, checkNames("münchen.de", []libdns.Record{libdns.TXT{Name: "www.xn--mnchen-3ya.com.", Text: "token"}}))
+
+	f := newFakeJoker(t)
+	c := newTestClient(t, f)
+	outside := []libdns.Record{libdns.TXT{Name: "_acme-challenge.example.org.", Text: "token"}}
+
+	_, err := c.AppendRecords(context.Background(), "example.com", outside)
+	assert.ErrorContains(t, err, "is not within zone")
+	_, err = c.SetRecords(context.Background(), "example.com", outside)
+	assert.ErrorContains(t, err, "is not within zone")
+	_, err = c.DeleteRecords(context.Background(), "example.com", outside)
+	assert.ErrorContains(t, err, "is not within zone")
+	assert.Empty(t, f.received(nicReplacePath))
+	assert.Empty(t, f.received(dmapiPath+"/login"))
+}
+
+func TestNicWarningOnce(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		record libdns.Record
+		want   int
+	}{
+		{name: "cname via nic", record: libdns.CNAME{Name: "www", Target: "example.com."}, want: 1},
+		{name: "mx via dmapi", record: libdns.MX{Name: "@", Preference: 10, Target: "mail.example.com."}},
+		{name: "txt via nic", record: libdns.TXT{Name: "_acme-challenge", Text: "token"}},
+		{name: "dmapi mode", mode: modeDMAPI, record: libdns.CNAME{Name: "www", Target: "example.com."}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			core, logs := observer.New(zap.WarnLevel)
+			c := newTestClient(t, f, func(c *Client) {
+				c.Mode = tt.mode
+				c.logger = zap.New(core)
+			})
+
+			for range 3 {
+				f.setZone("example.com")
+				_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{tt.record})
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, logs.FilterMessageSnippet("consider mode dmapi").Len())
+		})
+	}
+}
+
+func TestTypedRecord(t *testing.T) {
+	tests := []struct {
+		rr   libdns.RR
+		want libdns.Record
+	}{
+		{
+			rr:   libdns.RR{Name: "www", Type: "A", TTL: time.Hour, Data: "192.0.2.1"},
+			want: libdns.Address{Name: "www", TTL: time.Hour, IP: netip.MustParseAddr("192.0.2.1")},
+		},
+		{
+			rr:   libdns.RR{Name: "www", Type: "AAAA", TTL: time.Hour, Data: "2001:db8::1"},
+			want: libdns.Address{Name: "www", TTL: time.Hour, IP: netip.MustParseAddr("2001:db8::1")},
+		},
+		{
+			rr:   libdns.RR{Name: "www", Type: "CNAME", TTL: time.Hour, Data: "example.com."},
+			want: libdns.CNAME{Name: "www", TTL: time.Hour, Target: "example.com."},
+		},
+		{
+			rr:   libdns.RR{Name: "_acme-challenge", Type: "TXT", TTL: time.Hour, Data: "token"},
+			want: libdns.TXT{Name: "_acme-challenge", TTL: time.Hour, Text: "token"},
+		},
+		{
+			rr:   libdns.RR{Name: "@", Type: "MX", TTL: time.Hour, Data: "10 mail.example.com."},
+			want: libdns.MX{Name: "@", TTL: time.Hour, Preference: 10, Target: "mail.example.com."},
+		},
+		{
+			rr:   libdns.RR{Name: "sub", Type: "NS", TTL: time.Hour, Data: "ns1.example.net."},
+			want: libdns.NS{Name: "sub", TTL: time.Hour, Target: "ns1.example.net."},
+		},
+		{
+			rr: libdns.RR{Name: "_sip._tcp", Type: "SRV", TTL: time.Hour, Data: "10 60 5060 sip.example.com."},
+			want: libdns.SRV{
+				Service: "sip", Transport: "tcp", Name: "@", TTL: time.Hour,
+				Priority: 10, Weight: 60, Port: 5060, Target: "sip.example.com.",
+			},
+		},
+		{
+			rr:   libdns.RR{Name: "@", Type: "CAA", TTL: time.Hour, Data: `0 issue "letsencrypt.org"`},
+			want: libdns.CAA{Name: "@", TTL: time.Hour, Tag: "issue", Value: "letsencrypt.org"},
+		},
+		{
+			// libdns has no NAPTR struct
+			rr:   libdns.RR{Name: "@", Type: "NAPTR", TTL: time.Hour, Data: `100 10 "U" "E2U+sip" "!^.*$!sip:info@example.com!" .`},
+			want: libdns.RR{Name: "@", Type: "NAPTR", TTL: time.Hour, Data: `100 10 "U" "E2U+sip" "!^.*$!sip:info@example.com!" .`},
+		},
+		{
+			// Data that doesn't parse stays a plain RR
+			rr:   libdns.RR{Name: "@", Type: "MX", TTL: time.Hour, Data: "mail.example.com."},
+			want: libdns.RR{Name: "@", Type: "MX", TTL: time.Hour, Data: "mail.example.com."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rr.Type+" "+tt.rr.Data, func(t *testing.T) {
+			rec := typedRecord(tt.rr)
+			assert.Equal(t, tt.want, rec)
+			assert.Equal(t, tt.rr, rec.RR())
+		})
+	}
+}
+
+func TestDeleteRecordsContinueOnError(t *testing.T) {
+	records := []libdns.Record{
+		libdns.TXT{Name: "one", Text: "token"},
+		libdns.TXT{Name: "bad1", Text: "token"},
+		libdns.TXT{Name: "two", Text: "token"},
+		libdns.TXT{Name: "bad2", Text: "token"},
+	}
+
+	f := newFakeJoker(t)
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Form.Get("label"), "bad") {
+			http.Error(w, "locked", http.StatusBadRequest)
+			return
+		}
+		f.serveReplace(w, r.Form)
+	}
+	reset := func() {
+		f.setZone("example.com",
+			`one TXT 0 "token" 300`, `bad1 TXT 0 "token" 300`,
+			`two TXT 0 "token" 300`, `bad2 TXT 0 "token" 300`,
+		)
+		f.forget()
+	}
+
+	t.Run("continue", func(t *testing.T) {
+		reset()
+		c := newTestClient(t, f, func(c *Client) {
+			c.ContinueOnError = true
+		})
+
+		deleted, err := c.DeleteRecords(context.Background(), "example.com", records)
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "bad1 TXT in example.com")
+		assert.ErrorContains(t, err, "bad2 TXT in example.com")
+		assert.Len(t, f.received(nicReplacePath), 4)
+
+		var names []string
+		for _, rec := range deleted {
+			names = append(names, rec.RR().Name)
+		}
+		assert.ElementsMatch(t, []string{"one", "two"}, names)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		reset()
+		c := newTestClient(t, f)
+
+		deleted, err := c.DeleteRecords(context.Background(), "example.com", records)
+		require.Error(t, err)
+		assert.Less(t, len(f.received(nicReplacePath)), 4)
+		assert.Len(t, deleted, len(f.received(nicReplacePath))-1)
+	})
+}
+
+func TestRawTXT(t *testing.T) {
+	const value = `"quoted data"`
+
+	tests := []struct {
+		name   string
+		rawTXT bool
+		want   string
+	}{
+		{name: "default strips quotes", want: "quoted data"},
+		{name: "raw keeps quotes", rawTXT: true, want: value},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			c := newTestClient(t, f, func(c *Client) {
+				c.RawTXT = tt.rawTXT
+			})
+
+			added, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "note", Text: value},
+			})
+			require.NoError(t, err)
+
+			reqs := f.received(nicReplacePath)
+			require.Len(t, reqs, 1)
+			assert.Equal(t, tt.want, reqs[0].Form.Get("value"))
+			require.Len(t, added, 1)
+			assert.Equal(t, tt.want, added[0].RR().Data)
+		})
+	}
+}
+
+func TestTTLSeconds(t *testing.T) {
+	tests := []struct {
+		ttl         time.Duration
+		want        int
+		wantSuspect bool
+	}{
+		{ttl: 5 * time.Minute, want: 300},
+		{ttl: TTLFromSeconds(300), want: 300},
+		{ttl: 1500 * time.Millisecond, want: 1},
+		{ttl: 0, want: 0},
+		{ttl: 300, want: 300, wantSuspect: true},
+		{ttl: time.Second - 1, want: int(time.Second - 1), wantSuspect: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ttl.String(), func(t *testing.T) {
+			secs, suspect := ttlSeconds(tt.ttl)
+			assert.Equal(t, tt.want, secs)
+			assert.Equal(t, tt.wantSuspect, suspect)
+		})
+	}
+}
+
+func TestTTLMeantAsSeconds(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	core, logs := observer.New(zap.WarnLevel)
+	c := newTestClient(t, f, func(c *Client) {
+		c.logger = zap.New(core)
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", TTL: 300, Text: "token"},
+	})
+	require.NoError(t, err)
+
+	reqs := f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "300", reqs[0].Form.Get("ttl"))
+
+	warnings := logs.FilterMessageSnippet("assuming it was meant in seconds").All()
+	require.Len(t, warnings, 1)
+	assert.Equal(t, int64(300), warnings[0].ContextMap()["seconds"])
+}
+
+func TestSkipUnchanged(t *testing.T) {
+	tests := []struct {
+		name          string
+		skipUnchanged bool
+		wantWrites    int
+	}{
+		{name: "default", wantWrites: 1},
+		{name: "skip unchanged", skipUnchanged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com", `_acme-challenge TXT 0 "token" 3600`)
+			c := newTestClient(t, f, func(c *Client) {
+				c.SkipUnchanged = tt.skipUnchanged
+			})
+
+			results, err := c.AppendRecordsDetailed(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", TTL: 5 * time.Minute, Text: "token"},
+			})
+			require.NoError(t, err)
+			require.Len(t, results, 1)
+			assert.NoError(t, results[0].Err)
+
+			assert.Len(t, f.received(nicReplacePath), tt.wantWrites)
+			if tt.skipUnchanged {
+				// The stored record keeps its TTL
+				assert.False(t, results[0].Created)
+				assert.Equal(t, []string{`_acme-challenge TXT 0 "token" 3600`}, f.zone("example.com"))
+			}
+		})
+	}
+}
+
+func TestSuccessStatuses(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		status   int
+		body     string
+		// wantStatusErr expects the status itself to be rejected
+		wantStatusErr bool
+		wantErr       error
+	}{
+		{name: "204 by default", status: http.StatusNoContent, wantStatusErr: true},
+		{name: "204 allowed", statuses: []int{200, 204}, status: http.StatusNoContent},
+		{name: "201 allowed with body", statuses: []int{201}, status: http.StatusCreated, body: "OK"},
+		{name: "201 allowed with error body", statuses: []int{201}, status: http.StatusCreated, body: "badauth", wantErr: ErrAuth},
+		{name: "200 not listed", statuses: []int{204}, status: http.StatusOK, body: "OK", wantStatusErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			f.replace = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}
+			c := newTestClient(t, f, func(c *Client) {
+				c.SuccessStatuses = tt.statuses
+			})
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			switch {
+			case tt.wantStatusErr:
+				var apiErr *APIError
+				require.ErrorAs(t, err, &apiErr)
+				assert.Equal(t, tt.status, apiErr.StatusCode)
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestEmptyZone(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.setZone("example.org")
+	f.setZone("sub.example.org")
+	c := newTestClient(t, f)
+
+	added, err := c.AppendRecords(context.Background(), "", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge.example.com.", Text: "one"},
+		libdns.TXT{Name: "_acme-challenge.www.example.org.", Text: "two"},
+		libdns.TXT{Name: "_acme-challenge.sub.example.org.", Text: "three"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, added, 3)
+
+	assert.Equal(t, []string{`_acme-challenge TXT 0 "one" 3600`}, f.zone("example.com"))
+	assert.Equal(t, []string{`_acme-challenge.www TXT 0 "two" 3600`}, f.zone("example.org"))
+	assert.Equal(t, []string{`_acme-challenge TXT 0 "three" 3600`}, f.zone("sub.example.org"))
+
+	deleted, err := c.DeleteRecords(context.Background(), "", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge.example.com.", Text: "one"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, deleted, 1)
+	assert.Empty(t, f.zone("example.com"))
+}
+
+func TestEmptyZoneErrors(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	_, err := c.AppendRecords(context.Background(), "", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge.example.net.", Text: "token"},
+	})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = c.GetRecords(context.Background(), "")
+	assert.ErrorContains(t, err, "zone is required")
+
+	assert.Empty(t, f.received(nicReplacePath))
+}
+
+func TestPresentZoneJoinsRelativeNames(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	_, err := c.AppendRecords(context.Background(), "example.com.", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge.www", Text: "relative"},
+		libdns.TXT{Name: "_acme-challenge.example.com.", Text: "absolute"},
+	})
+	require.NoError(t, err)
+
+	labels := make(map[string]string)
+	for _, r := range f.received(nicReplacePath) {
+		assert.Equal(t, "example.com", r.Form.Get("zone"))
+		labels[r.Form.Get("value")] = r.Form.Get("label")
+	}
+	assert.Equal(t, map[string]string{
+		"relative": "_acme-challenge.www",
+		"absolute": "_acme-challenge",
+	}, labels)
+}
+
+func TestFieldMap(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "good 192.0.2.1")
+	}
+	core, logs := observer.New(zap.DebugLevel)
+	c := newTestClient(t, f, func(c *Client) {
+		c.FieldMap = map[string]string{
+			"value":     "myip",
+			"label":     "hostname",
+			"api_token": "key",
+		}
+		c.logger = zap.New(core)
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.Address{Name: "home", IP: netip.MustParseAddr("192.0.2.1")},
+	})
+	require.NoError(t, err)
+
+	reqs := f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	form := reqs[0].Form
+	assert.Equal(t, "192.0.2.1", form.Get("myip"))
+	assert.Equal(t, "home", form.Get("hostname"))
+	assert.Equal(t, "secret-token", form.Get("key"))
+	assert.Equal(t, "example.com", form.Get("zone"))
+	for _, field := range []string{"value", "label", "api_token"} {
+		assert.NotContains(t, form, field)
+	}
+
+	// A renamed secret is still redacted when the form is logged
+	require.NotZero(t, logs.FilterMessage("joker request form").Len())
+	for _, entry := range logs.All() {
+		assert.NotContains(t, fmt.Sprint(entry.ContextMap()), "secret-token")
+	}
+}
+
+func TestFieldMapInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		fieldMap map[string]string
+		wantErr  string
+	}{
+		{name: "unknown field", fieldMap: map[string]string{"hostname": "host"}, wantErr: `field_map: unknown field "hostname"`},
+		{name: "empty name", fieldMap: map[string]string{"value": ""}, wantErr: `field_map: empty name for field "value"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{APIToken: "secret-token", FieldMap: tt.fieldMap}
+			require.NoError(t, c.setup())
+			assert.ErrorContains(t, c.Validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestJSONContentType(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f, func(c *Client) {
+		c.ContentType = contentTypeJSON
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", TTL: 5 * time.Minute, Text: "token"},
+	})
+	require.NoError(t, err)
+
+	reqs := f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, http.MethodPost, reqs[0].Method)
+	assert.Equal(t, "application/json", reqs[0].Header.Get("Content-Type"))
+	// The fake decodes the body as a flat object of strings
+	assert.Equal(t, url.Values{
+		"api_token": {"secret-token"},
+		"zone":      {"example.com"},
+		"label":     {"_acme-challenge"},
+		"type":      {"TXT"},
+		"ttl":       {"300"},
+		"value":     {"token"},
+	}, reqs[0].Form)
+}
+
+func TestJSONContentTypeResponse(t *testing.T) {
+	tests := []struct {
+		body    string
+		wantErr error
+		// wantUnexpected expects the body to be rejected as unexpected
+		wantUnexpected bool
+	}{
+		{body: `{"status":"ok"}`},
+		{body: `{"result":"GOOD"}`},
+		{body: "OK"},
+		{body: `{"status":"nochg"}`, wantErr: ErrNoChange},
+		{body: `{"result":"ko","message":"Authentication error"}`, wantErr: ErrAuth},
+		{body: `{"status":"badauth"}`, wantErr: ErrAuth},
+		{body: `{"something":"else"}`, wantUnexpected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			f.replace = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, tt.body)
+			}
+			c := newTestClient(t, f, func(c *Client) {
+				c.ContentType = contentTypeJSON
+				c.ReportNoChange = true
+			})
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			switch {
+			case tt.wantUnexpected:
+				assert.ErrorContains(t, err, "unexpected response")
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestJSONContentTypeInvalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		method      string
+		wantErr     string
+	}{
+		{name: "with GET", contentType: contentTypeJSON, method: http.MethodGet, wantErr: `content_type "json" requires http_method POST`},
+		{name: "unknown", contentType: "xml", wantErr: `unknown content_type "xml"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{APIToken: "secret-token", ContentType: tt.contentType, HTTPMethod: tt.method}
+			require.NoError(t, c.setup())
+			assert.ErrorContains(t, c.Validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestAppendRecordsDetailed(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com", `_acme-challenge TXT 0 "old" 300`)
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		if r.Form.Get("label") == "broken" {
+			fmt.Fprint(w, "dnserr")
+			return
+		}
+		f.serveReplace(w, r.Form)
+	}
+	c := newTestClient(t, f, func(c *Client) {
+		c.ContinueOnError = true
+	})
+	records := []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", TTL: 5 * time.Minute, Text: "old"},
+		libdns.TXT{Name: "_acme-challenge", TTL: 5 * time.Minute, Text: "new"},
+		libdns.Address{Name: "broken", TTL: 5 * time.Minute, IP: netip.MustParseAddr("192.0.2.1")},
+	}
+
+	results, err := c.AppendRecordsDetailed(context.Background(), "example.com", records)
+	require.Error(t, err)
+	require.Len(t, results, 3)
+
+	byData := make(map[string]AppendResult)
+	for _, r := range results {
+		byData[r.Record.RR().Data] = r
+	}
+
+	assert.NoError(t, byData["old"].Err)
+	assert.False(t, byData["old"].Created)
+
+	assert.NoError(t, byData["new"].Err)
+	assert.True(t, byData["new"].Created)
+
+	assert.ErrorContains(t, byData["192.0.2.1"].Err, "DNS error on server")
+	assert.False(t, byData["192.0.2.1"].Created)
+
+	// AppendRecords returns just the records that were appended
+	f.setZone("example.com", `_acme-challenge TXT 0 "old" 300`)
+	added, err := c.AppendRecords(context.Background(), "example.com", records)
+	require.Error(t, err)
+	var data []string
+	for _, rec := range added {
+		data = append(data, rec.RR().Data)
+	}
+	assert.ElementsMatch(t, []string{"old", "new"}, data)
+}
+
+func TestSupportedRecordTypes(t *testing.T) {
+	types := (&Client{}).SupportedRecordTypes()
+
+	require.NotEmpty(t, types)
+	assert.Contains(t, types, "TXT")
+	assert.Contains(t, types, "A")
+	assert.True(t, slices.IsSorted(types))
+
+	// The list is what the record methods accept
+	for _, rtype := range types {
+		assert.NoError(t, checkTypes([]libdns.Record{libdns.RR{Name: "x", Type: rtype}}), rtype)
+	}
+	for rtype := range unsupportedTypeReasons {
+		assert.NotContains(t, types, rtype)
+	}
+}
+
+func TestDeleteAllAtName(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com",
+		`_acme-challenge TXT 0 "one" 300`,
+		`_acme-challenge TXT 0 "two" 300`,
+		`_acme-challenge TXT 0 "three" 300`,
+		"_acme-challenge A 0 192.0.2.1 300",
+		`_acme-challenge.www TXT 0 "four" 300`,
+	)
+	c := newTestClient(t, f)
+
+	deleted, err := c.DeleteAllAtName(context.Background(), "example.com", "_acme-challenge", "txt")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "one"},
+		libdns.TXT{Name: "_acme-challenge", Text: "two"},
+		libdns.TXT{Name: "_acme-challenge", Text: "three"},
+	}, deleted)
+
+	// One rewrite of the RRset removes every value
+	reqs := f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	assert.Empty(t, reqs[0].Form.Get("value"))
+	assert.ElementsMatch(t, []string{
+		"_acme-challenge A 0 192.0.2.1 300",
+		`_acme-challenge.www TXT 0 "four" 300`,
+	}, f.zone("example.com"))
+
+	// Nothing left to delete: nothing is written
+	f.forget()
+	deleted, err = c.DeleteAllAtName(context.Background(), "example.com", "_acme-challenge", "TXT")
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+	assert.Empty(t, f.received(nicReplacePath))
+}
+
+func TestDeleteAllAtNameFullyQualified(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com", `_acme-challenge.www TXT 0 "one" 300`, `_acme-challenge.www TXT 0 "two" 300`)
+	c := newTestClient(t, f)
+
+	deleted, err := c.DeleteAllAtName(context.Background(), "", "_acme-challenge.www.example.com.", "TXT")
+	require.NoError(t, err)
+	assert.Len(t, deleted, 2)
+	assert.Empty(t, f.zone("example.com"))
+}
+
+func TestDeleteAllAtNameUnreadable(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com", `_acme-challenge TXT 0 "one" 300`)
+	f.dmapi = func(w http.ResponseWriter, cmd string, form url.Values) bool {
+		if cmd != "dns-zone-get" {
+			return false
+		}
+		fmt.Fprint(w, "Status-Code: 2400\nStatus-Text: Command failed\n\n")
+		return true
+	}
+	c := newTestClient(t, f)
+
+	deleted, err := c.DeleteAllAtName(context.Background(), "example.com", "_acme-challenge", "TXT")
+	require.NoError(t, err)
+	assert.Nil(t, deleted)
+	assert.Empty(t, f.zone("example.com"))
+}
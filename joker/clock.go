@@ -0,0 +1,23 @@
+package joker
+
+import "time"
+
+// clock is the source of time for retries, session expiry and
+// propagation polling, so tests can drive them without real waits.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clk returns the Client's clock, defaulting to the real one.
+func (c *Client) clk() clock {
+	if c.clock == nil {
+		return realClock{}
+	}
+	return c.clock
+}
@@ -0,0 +1,54 @@
+package joker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libdns/libdns"
+)
+
+func TestBackoffSchedule(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+	}
+	clk := newFakeClock()
+	c := newTestClient(t, f, func(c *Client) {
+		c.MaxRetries = 5
+		c.clock = clk
+		c.jitter = noJitter
+	})
+
+	start := time.Now()
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.Error(t, err)
+
+	assert.Equal(t, []time.Duration{
+		1 * time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		16 * time.Second,
+	}, clk.waited())
+	assert.Equal(t, 31*time.Second, clk.Now().Sub(newFakeClock().Now()))
+	assert.Less(t, time.Since(start), 5*time.Second, "the fake clock doesn't sleep")
+	assert.Len(t, f.received(nicReplacePath), 6)
+}
+
+func TestDefaultClock(t *testing.T) {
+	var c Client
+	assert.Equal(t, realClock{}, c.clk())
+	assert.WithinDuration(t, time.Now(), c.clk().Now(), time.Second)
+
+	clk := newFakeClock()
+	c.clock = clk
+	assert.Same(t, clk, c.clk())
+}
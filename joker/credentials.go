@@ -0,0 +1,85 @@
+package joker
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Credential authenticates with Joker, either by API token or by username
+// and password.
+type Credential struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	APIToken string `json:"api_token,omitempty"`
+}
+
+// validate checks that exactly one authentication method is configured,
+// completely, and that no value contains control characters.
+func (c Credential) validate() error {
+	for name, v := range map[string]string{
+		"username":  c.Username,
+		"password":  c.Password,
+		"api_token": c.APIToken,
+	} {
+		if strings.ContainsFunc(v, unicode.IsControl) {
+			return fmt.Errorf("%s contains control characters (e.g. a stray newline)", name)
+		}
+	}
+
+	hasToken := c.APIToken != ""
+
+	switch {
+	case hasToken && (c.Username != "" || c.Password != ""):
+		return fmt.Errorf("configure either api_token/api_key or username/password, not both")
+	case hasToken:
+		return nil
+	case c.Username != "" && c.Password == "":
+		return fmt.Errorf("username is set but password is missing")
+	case c.Password != "" && c.Username == "":
+		return fmt.Errorf("password is set but username is missing")
+	case c.Username != "":
+		return nil
+	default:
+		return fmt.Errorf("either api_token/api_key or username/password must be configured")
+	}
+}
+
+// zoneAuth is the credential of one ZoneCredentials entry and its DMAPI
+// session.
+type zoneAuth struct {
+	cred    Credential
+	session *dmapiSession
+}
+
+// setupZoneAuths indexes ZoneCredentials by normalized ASCII zone name,
+// each with its own DMAPI session.
+func (c *Client) setupZoneAuths() error {
+	c.zoneAuths = make(map[string]*zoneAuth, len(c.ZoneCredentials))
+	for zone, cred := range c.ZoneCredentials {
+		z, err := toASCII(normalizeZone(zone))
+		if err != nil {
+			return fmt.Errorf("zone_credentials: %w", err)
+		}
+		c.zoneAuths[z] = &zoneAuth{cred: cred, session: new(dmapiSession)}
+	}
+	return nil
+}
+
+// authFor returns the credential and DMAPI session to use for zone: its
+// ZoneCredentials entry if there is one, otherwise the Client's own.
+// An empty zone (account-wide commands) always uses the Client's own.
+func (c *Client) authFor(zone string) (Credential, *dmapiSession) {
+	if zone != "" {
+		if z, err := toASCII(normalizeZone(zone)); err == nil {
+			if za, ok := c.zoneAuths[z]; ok {
+				return za.cred, za.session
+			}
+		}
+	}
+	return Credential{
+		Username: c.Username,
+		Password: c.Password,
+		APIToken: c.APIToken,
+	}, c.session
+}
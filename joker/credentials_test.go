@@ -0,0 +1,370 @@
+package joker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libdns/libdns"
+)
+
+// writeSecret writes content to name in dir and returns its path.
+func writeSecret(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestCredentialFiles(t *testing.T) {
+	dir := t.TempDir()
+	c := &Client{
+		UsernameFile: writeSecret(t, dir, "user", "alice\n"),
+		PasswordFile: writeSecret(t, dir, "pass", "s3cret \r\n"),
+	}
+
+	require.NoError(t, c.Setup())
+	require.NoError(t, c.Validate())
+	assert.Equal(t, "alice", c.Username)
+	assert.Equal(t, "s3cret", c.Password)
+}
+
+func TestCredentialFilesInvalid(t *testing.T) {
+	dir := t.TempDir()
+	user := writeSecret(t, dir, "user", "alice\n")
+	empty := writeSecret(t, dir, "empty", " \n")
+
+	tests := []struct {
+		name    string
+		p       Client
+		wantErr string
+	}{
+		{
+			name:    "missing",
+			p:       Client{UsernameFile: user, PasswordFile: filepath.Join(dir, "missing")},
+			wantErr: "reading password_file",
+		},
+		{
+			name:    "empty",
+			p:       Client{UsernameFile: empty, Password: "s3cret"},
+			wantErr: "is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorContains(t, tt.p.Setup(), tt.wantErr)
+		})
+	}
+}
+
+func TestSecretsDir(t *testing.T) {
+	t.Run("username and password", func(t *testing.T) {
+		dir := t.TempDir()
+		writeSecret(t, dir, "username", "alice\n")
+		writeSecret(t, dir, "password", "s3cret\n")
+		c := &Client{SecretsDir: dir}
+
+		require.NoError(t, c.Setup())
+		require.NoError(t, c.Validate())
+		assert.Equal(t, "alice", c.Username)
+		assert.Equal(t, "s3cret", c.Password)
+		assert.Empty(t, c.APIToken)
+	})
+
+	t.Run("api token", func(t *testing.T) {
+		dir := t.TempDir()
+		writeSecret(t, dir, "api_token", "secret-token\n")
+		c := &Client{SecretsDir: dir}
+
+		require.NoError(t, c.Setup())
+		require.NoError(t, c.Validate())
+		assert.Equal(t, "secret-token", c.APIToken)
+	})
+
+	t.Run("password file beside it", func(t *testing.T) {
+		dir := t.TempDir()
+		writeSecret(t, dir, "username", "alice\n")
+		c := &Client{
+			SecretsDir:   dir,
+			PasswordFile: writeSecret(t, t.TempDir(), "pass", "s3cret\n"),
+		}
+
+		require.NoError(t, c.Setup())
+		assert.Equal(t, "alice", c.Username)
+		assert.Equal(t, "s3cret", c.Password)
+	})
+
+	t.Run("also inline", func(t *testing.T) {
+		dir := t.TempDir()
+		writeSecret(t, dir, "password", "s3cret\n")
+		c := &Client{SecretsDir: dir, Username: "alice", Password: "inline"}
+
+		assert.ErrorContains(t, c.Setup(), "secrets_dir contains password, which is also set by password")
+	})
+}
+
+func TestCredentialControlCharacters(t *testing.T) {
+	tests := []struct {
+		name    string
+		cred    Credential
+		wantErr string
+	}{
+		{name: "newline in password", cred: Credential{Username: "alice", Password: "s3cret\n"}, wantErr: "password contains control characters"},
+		{name: "carriage return in username", cred: Credential{Username: "alice\r", Password: "s3cret"}, wantErr: "username contains control characters"},
+		{name: "NUL in token", cred: Credential{APIToken: "secret\x00token"}, wantErr: "api_token contains control characters"},
+		{name: "clean", cred: Credential{Username: "alice", Password: "s3 cr&t"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{
+				Username: tt.cred.Username,
+				Password: tt.cred.Password,
+				APIToken: tt.cred.APIToken,
+			}
+			err := c.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+
+			// A zone's credentials are checked the same way
+			c = &Client{
+				APIToken:        "secret-token",
+				ZoneCredentials: map[string]Credential{"example.org": tt.cred},
+			}
+			assert.ErrorContains(t, c.Validate(), "zone_credentials example.org: "+tt.wantErr)
+		})
+	}
+}
+
+func TestZoneCredentials(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.setZone("example.org")
+	c := newTestClient(t, f, func(c *Client) {
+		c.ZoneCredentials = map[string]Credential{
+			"Example.ORG.": {Username: "bob", Password: "hunter2"},
+		}
+	})
+
+	for _, zone := range []string{"example.com", "example.org"} {
+		_, err := c.AppendRecords(context.Background(), zone, []libdns.Record{
+			libdns.TXT{Name: "_acme-challenge", Text: "token"},
+		})
+		require.NoError(t, err)
+	}
+
+	byZone := make(map[string]url.Values)
+	for _, r := range f.received(nicReplacePath) {
+		byZone[r.Form.Get("zone")] = r.Form
+	}
+	require.Len(t, byZone, 2)
+
+	assert.Equal(t, "secret-token", byZone["example.com"].Get("api_token"))
+	assert.Empty(t, byZone["example.com"].Get("username"))
+
+	assert.Equal(t, "bob", byZone["example.org"].Get("username"))
+	assert.Equal(t, "hunter2", byZone["example.org"].Get("password"))
+	assert.Empty(t, byZone["example.org"].Get("api_token"))
+
+	// Each zone logs in to DMAPI with its own credentials
+	var keys, users []string
+	for _, r := range f.received(dmapiPath + "/login") {
+		if k := r.Form.Get("api-key"); k != "" {
+			keys = append(keys, k)
+		}
+		if u := r.Form.Get("username"); u != "" {
+			users = append(users, u)
+		}
+	}
+	assert.Equal(t, []string{"secret-token"}, keys)
+	assert.Equal(t, []string{"bob"}, users)
+}
+
+func TestEmptyZoneFindsZoneCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string // query-domain-list answer for the default account
+		wantErr string // for a name in neither
+	}{
+		{
+			name:    "other account",
+			list:    "Status-Code: 0\nStatus-Text: OK\n\nexample.com 2030-01-01\n",
+			wantErr: `no zone in the account contains "_acme-challenge.example.net."`,
+		},
+		{
+			name:    "listing fails",
+			list:    "Status-Code: 2400\nStatus-Text: Command failed\n\n",
+			wantErr: "finding zones of records",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			f.setZone("example.org")
+			f.dmapi = func(w http.ResponseWriter, cmd string, form url.Values) bool {
+				if cmd != "query-domain-list" {
+					return false
+				}
+				fmt.Fprint(w, tt.list)
+				return true
+			}
+			c := newTestClient(t, f, func(c *Client) {
+				c.ZoneCredentials = map[string]Credential{
+					"Example.ORG.": {Username: "bob", Password: "hunter2"},
+				}
+			})
+
+			_, err := c.AppendRecords(context.Background(), "", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge.www.example.org.", Text: "token"},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []string{`_acme-challenge.www TXT 0 "token" 3600`}, f.zone("example.org"))
+
+			reqs := f.received(nicReplacePath)
+			require.Len(t, reqs, 1)
+			assert.Equal(t, "example.org", reqs[0].Form.Get("zone"))
+			assert.Equal(t, "bob", reqs[0].Form.Get("username"))
+
+			_, err = c.AppendRecords(context.Background(), "", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge.example.net.", Text: "token"},
+			})
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.Len(t, f.received(nicReplacePath), 1)
+		})
+	}
+}
+
+func TestAuthCombinations(t *testing.T) {
+	files := t.TempDir()
+	userFile := writeSecret(t, files, "user", "alice\n")
+	passFile := writeSecret(t, files, "pass", "s3cret\n")
+
+	tests := []struct {
+		name string
+		p    Client
+		// secrets are written to a secrets_dir, if any
+		secrets map[string]string
+		wantErr string
+	}{
+		{
+			name:    "username inline and from file",
+			p:       Client{Username: "alice", UsernameFile: userFile, Password: "s3cret"},
+			wantErr: "username and username_file are both set",
+		},
+		{
+			name:    "password inline and from file",
+			p:       Client{Username: "alice", Password: "s3cret", PasswordFile: passFile},
+			wantErr: "password and password_file are both set",
+		},
+		{
+			name:    "api token and username file",
+			p:       Client{APIToken: "secret-token", UsernameFile: userFile},
+			wantErr: "api_token and username_file select different authentication methods",
+		},
+		{
+			name:    "api key and credential files",
+			p:       Client{APIKey: "key-123", UsernameFile: userFile, PasswordFile: passFile},
+			wantErr: "api_key and username_file and password_file select different authentication methods",
+		},
+		{
+			name:    "api token and username/password",
+			p:       Client{APIToken: "secret-token", Username: "alice", Password: "s3cret"},
+			wantErr: "configure either api_token/api_key or username/password, not both",
+		},
+		{
+			name:    "api key and api token differ",
+			p:       Client{APIKey: "key-123", APIToken: "secret-token"},
+			wantErr: "api_key and api_token are aliases",
+		},
+		{
+			name:    "username without password",
+			p:       Client{Username: "alice"},
+			wantErr: "username is set but password is missing",
+		},
+		{
+			name:    "password file without username",
+			p:       Client{PasswordFile: passFile},
+			wantErr: "password is set but username is missing",
+		},
+		{
+			name:    "nothing",
+			wantErr: "either api_token/api_key or username/password must be configured",
+		},
+		{
+			name:    "secrets_dir and username file",
+			p:       Client{UsernameFile: userFile, Password: "s3cret"},
+			secrets: map[string]string{"username": "bob"},
+			wantErr: "secrets_dir contains username, which is also set by username_file",
+		},
+		{
+			name:    "secrets_dir and api key",
+			p:       Client{APIKey: "key-123"},
+			secrets: map[string]string{"api_token": "secret-token"},
+			wantErr: "secrets_dir contains api_token, which is also set by api_key",
+		},
+		{
+			name:    "secrets_dir with both methods",
+			secrets: map[string]string{"username": "alice", "password": "s3cret", "api_token": "secret-token"},
+			wantErr: "not both",
+		},
+		{
+			name:    "zone credentials without a zone",
+			p:       Client{APIToken: "secret-token", ZoneCredentials: map[string]Credential{".": {APIToken: "other"}}},
+			wantErr: "zone_credentials: empty zone name",
+		},
+		{
+			name:    "incomplete zone credentials",
+			p:       Client{APIToken: "secret-token", ZoneCredentials: map[string]Credential{"example.org": {Password: "s3cret"}}},
+			wantErr: "zone_credentials example.org: password is set but username is missing",
+		},
+		{
+			name: "files",
+			p:    Client{UsernameFile: userFile, PasswordFile: passFile},
+		},
+		{
+			name:    "secrets_dir beside an inline password",
+			p:       Client{Password: "s3cret"},
+			secrets: map[string]string{"username": "alice"},
+		},
+		{
+			name: "api key alone",
+			p:    Client{APIKey: "key-123"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.p
+			if tt.secrets != nil {
+				c.SecretsDir = t.TempDir()
+				for name, v := range tt.secrets {
+					writeSecret(t, c.SecretsDir, name, v)
+				}
+			}
+
+			err := c.Setup()
+			if err == nil {
+				err = c.Validate()
+			}
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
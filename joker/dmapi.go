@@ -0,0 +1,736 @@
+package joker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/libdns/libdns"
+)
+
+// dmapiResponse is a parsed Joker DMAPI reply: a block of "Key: value"
+// header lines, a blank line, then the command specific body.
+type dmapiResponse struct {
+	headers map[string]string
+	body    string
+}
+
+// dmapiRequest issues a DMAPI command and returns the parsed reply.
+// A non-zero Status-Code is returned as an error.
+func (c *Client) dmapiRequest(
+	ctx context.Context,
+	cmd string,
+	form url.Values,
+) (*dmapiResponse, error) {
+	endpoint := strings.TrimSuffix(c.DMAPIEndpoint, "/") + "/" + cmd
+
+	status, body, err := c.postForm(ctx, endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := parseDMAPIResponse(string(body))
+
+	if status != http.StatusOK || resp.headers["Status-Code"] != "0" {
+		statusText := c.redact(resp.headers["Status-Text"])
+		c.log(ctx).Warn("joker DMAPI error",
+			zap.String("command", cmd),
+			zap.Int("status", status),
+			zap.String("status_code", resp.headers["Status-Code"]),
+			zap.String("status_text", statusText),
+		)
+		apiErr := newStatusError(status, strings.TrimSpace(c.redactBody(resp.body)))
+		if apiErr.Err == nil && strings.Contains(strings.ToLower(statusText), "authori") {
+			apiErr.Err = ErrAuth
+		}
+		return nil, fmt.Errorf(
+			"joker DMAPI %s error: code=%s text=%s: %w",
+			cmd,
+			resp.headers["Status-Code"],
+			statusText,
+			apiErr,
+		)
+	}
+
+	return resp, nil
+}
+
+// dmapiLogin opens a DMAPI session for cred and returns its Auth-Sid.
+func (c *Client) dmapiLogin(ctx context.Context, cred Credential) (string, error) {
+	form := url.Values{}
+	if cred.APIToken != "" {
+		form.Set("api-key", cred.APIToken)
+	} else {
+		form.Set("username", cred.Username)
+		form.Set("password", cred.Password)
+	}
+
+	resp, err := c.dmapiRequest(ctx, "login", form)
+	if err != nil {
+		return "", err
+	}
+
+	sid := resp.headers["Auth-Sid"]
+	if sid == "" {
+		return "", fmt.Errorf("joker DMAPI login returned no Auth-Sid")
+	}
+	return sid, nil
+}
+
+// dmapiSessionLifetime is how long an Auth-Sid is reused before logging in
+// again. Joker expires idle sessions after an hour; stay well inside that.
+const dmapiSessionLifetime = 30 * time.Minute
+
+// dmapiSession caches the Auth-Sid of the current DMAPI session so it can
+// be reused across calls. While a login is in flight, loggingIn is closed
+// when it ends, so concurrent callers wait for it instead of logging in
+// too. zoneMu serializes zone rewrites, which are a read-modify-write of
+// the whole zone.
+type dmapiSession struct {
+	mu        sync.Mutex
+	sid       string
+	expires   time.Time
+	loggingIn chan struct{}
+
+	zoneMu sync.Mutex
+}
+
+// dmapiAuth returns the Auth-Sid to use for zone (see authFor), logging in
+// if there is no live session. The session lock is not held during the
+// login itself, and a write check on ctx is not applied to it: the login
+// is not the write, and checking would reenter dmapiAuth.
+func (c *Client) dmapiAuth(ctx context.Context, zone string) (string, error) {
+	ctx = withoutWriteCheck(ctx)
+
+	cred, session := c.authFor(zone)
+	if session == nil {
+		return c.dmapiLogin(ctx, cred)
+	}
+
+	for {
+		session.mu.Lock()
+		if session.sid != "" && c.clk().Now().Before(session.expires) {
+			sid := session.sid
+			session.mu.Unlock()
+			return sid, nil
+		}
+		if wait := session.loggingIn; wait != nil {
+			session.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		session.loggingIn = done
+		session.mu.Unlock()
+
+		sid, err := c.dmapiLogin(ctx, cred)
+
+		session.mu.Lock()
+		session.loggingIn = nil
+		if err == nil {
+			session.sid = sid
+			session.expires = c.clk().Now().Add(dmapiSessionLifetime)
+		}
+		session.mu.Unlock()
+		close(done)
+		return sid, err
+	}
+}
+
+// dmapiInvalidate drops zone's cached session if it is still sid, so the
+// next dmapiAuth logs in again.
+func (c *Client) dmapiInvalidate(zone, sid string) {
+	_, session := c.authFor(zone)
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.sid == sid {
+		session.sid = ""
+	}
+}
+
+// VerifyCredentials checks the configured credentials, including every
+// ZoneCredentials entry, by logging in to DMAPI, bypassing any cached
+// session. It returns nil on success and an error matching ErrAuth if
+// Joker rejects the credentials. The new sessions are kept for later
+// calls.
+func (c *Client) VerifyCredentials(ctx context.Context) error {
+	zones := []string{""}
+	for zone := range c.zoneAuths {
+		zones = append(zones, zone)
+	}
+
+	for _, zone := range zones {
+		cred, session := c.authFor(zone)
+		sid, err := c.dmapiLogin(ctx, cred)
+		if err != nil {
+			if zone != "" {
+				return fmt.Errorf("zone_credentials %s: %w", zone, err)
+			}
+			return err
+		}
+		if session != nil {
+			session.mu.Lock()
+			session.sid = sid
+			session.expires = c.clk().Now().Add(dmapiSessionLifetime)
+			session.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// dmapiCall issues an authenticated DMAPI command, with the session for
+// the command's domain. If the server rejects the session (e.g. it
+// expired early) it logs in again and retries once.
+func (c *Client) dmapiCall(
+	ctx context.Context,
+	cmd string,
+	form url.Values,
+) (*dmapiResponse, error) {
+	zone := form.Get("domain")
+
+	for attempt := 0; ; attempt++ {
+		sid, err := c.dmapiAuth(ctx, zone)
+		if err != nil {
+			return nil, err
+		}
+		form.Set("auth-sid", sid)
+
+		resp, err := c.dmapiRequest(ctx, cmd, form)
+		if err == nil || attempt > 0 || !errors.Is(err, ErrAuth) || c.session == nil {
+			return resp, err
+		}
+
+		c.log(ctx).Debug("joker DMAPI session rejected, logging in again",
+			zap.String("command", cmd),
+		)
+		c.dmapiInvalidate(zone, sid)
+	}
+}
+
+// GetRecords lists the records of zone via DMAPI dns-zone-get, or from
+// the cache within CacheTTL of the last listing.
+func (c *Client) GetRecords(
+	ctx context.Context,
+	zone string,
+) ([]libdns.Record, error) {
+	return c.getRecords(withRequestID(ctx), zone, true)
+}
+
+// getRecords is GetRecords, answering from the cache only if cached is
+// set. Reads that a write is computed from (values to merge or delete, a
+// diff) pass false, so a stale entry can't undo changes made elsewhere;
+// what they fetch is still cached.
+func (c *Client) getRecords(
+	ctx context.Context,
+	zone string,
+	cached bool,
+) ([]libdns.Record, error) {
+	if normalizeZone(zone) == "" {
+		return nil, errors.New("zone is required")
+	}
+	z, err := toASCII(normalizeZone(zone))
+	if err != nil {
+		return nil, err
+	}
+
+	records, gen, ok := c.cache.get(z, c.clk().Now())
+	if ok && cached {
+		c.log(ctx).Debug("using cached DNS records", zap.String("zone", z))
+		return records, nil
+	}
+
+	c.log(ctx).Debug("getting DNS records", zap.String("zone", z))
+
+	form := url.Values{}
+	form.Set("domain", z)
+
+	resp, err := c.dmapiCall(ctx, "dns-zone-get", form)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err = parseZone(resp.body, z, c.defaultTTL())
+	if err != nil {
+		return nil, err
+	}
+
+	// Report internationalized names in their Unicode form, as typed
+	// records
+	for i, rec := range records {
+		rr := rec.RR()
+		rr.Name = toUnicode(rr.Name)
+		records[i] = typedRecord(rr)
+	}
+
+	c.cache.put(z, gen, records, c.clk().Now().Add(time.Duration(c.CacheTTL)))
+	return records, nil
+}
+
+// GetRecord returns the first rtype record at name, and whether there is
+// one. DMAPI can only fetch whole zones, so this filters GetRecords (and
+// shares its cache); use GetRecords for every value of an RRset. As for
+// the write methods, name is relative to zone, or fully qualified if zone
+// is empty.
+func (c *Client) GetRecord(
+	ctx context.Context,
+	zone, name, rtype string,
+) (libdns.Record, bool, error) {
+	ctx = withRequestID(ctx)
+	rtype = strings.ToUpper(strings.TrimSpace(rtype))
+
+	if normalizeZone(zone) == "" {
+		z, err := c.zoneOf(ctx, name)
+		if err != nil {
+			return nil, false, err
+		}
+		zone = z
+	}
+	if err := checkNames(zone, []libdns.Record{libdns.RR{Name: name, Type: rtype}}); err != nil {
+		return nil, false, err
+	}
+
+	records, err := c.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, false, err
+	}
+
+	label := labelRelativeToZone(name, zone)
+	for _, rec := range records {
+		rr := rec.RR()
+		if rr.Type == rtype && sameLabel(labelRelativeToZone(rr.Name, zone), label) {
+			return rec, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// ListZones lists the domains of the account via DMAPI query-domain-list.
+func (c *Client) ListZones(ctx context.Context) ([]libdns.Zone, error) {
+	resp, err := c.dmapiCall(ctx, "query-domain-list", url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	// One domain per line, by default first and followed by its
+	// expiration date
+	col := max(slices.Index(resp.columns(), "domain"), 0)
+
+	var zones []libdns.Zone
+	for _, row := range resp.rows() {
+		if col >= len(row) {
+			continue
+		}
+		zones = append(zones, libdns.Zone{Name: normalizeZone(row[col]) + "."})
+	}
+	return zones, nil
+}
+
+// dmapiOnlyTypes are record types /nic/replace cannot represent (it has
+// no field for e.g. MX priority); these are written through DMAPI.
+var dmapiOnlyTypes = map[string]bool{
+	"MX":  true,
+	"SRV": true,
+	"CAA": true,
+}
+
+// dmapiReplaceRRSet rewrites the label/type RRset of zone through DMAPI by
+// fetching the zone, swapping the matching lines for values and putting
+// it back. DMAPI has no per-record delete: an empty values deletes the
+// RRset by leaving its lines out of the zone, so nothing blank remains.
+// Deleting an RRset that doesn't exist returns ErrNoChange without
+// writing the zone.
+func (c *Client) dmapiReplaceRRSet(
+	ctx context.Context,
+	zone, label, rtype string,
+	values []string,
+	ttl int,
+) error {
+	var newLines []string
+	for _, v := range values {
+		line, err := formatZoneLine(label, rtype, v, ttl)
+		if err != nil {
+			return err
+		}
+		newLines = append(newLines, line)
+	}
+
+	if _, session := c.authFor(zone); session != nil {
+		session.zoneMu.Lock()
+		defer session.zoneMu.Unlock()
+	}
+
+	form := url.Values{}
+	form.Set("domain", zone)
+
+	resp, err := c.dmapiCall(ctx, "dns-zone-get", form)
+	if err != nil {
+		return err
+	}
+
+	var (
+		lines   []string
+		removed int
+	)
+	for _, line := range strings.Split(resp.body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if zoneLineMatches(line, label, rtype, zone) {
+			removed++
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(newLines) == 0 && removed == 0 {
+		c.log(ctx).Debug("joker DMAPI record already absent",
+			zap.String("zone", zone),
+			zap.String("label", label),
+			zap.String("type", rtype),
+		)
+		return ErrNoChange
+	}
+	lines = append(lines, newLines...)
+
+	c.log(ctx).Debug("joker DMAPI zone update",
+		zap.String("zone", zone),
+		zap.String("label", label),
+		zap.String("type", rtype),
+		zap.Int("ttl", ttl),
+		zap.Strings("values", values),
+	)
+
+	form.Set("zone", strings.Join(lines, "\n")+"\n")
+
+	wctx := c.withWriteCheck(ctx, zone, label, rtype, values, ttl)
+	_, err = c.dmapiCall(wctx, "dns-zone-put", form)
+	if errors.Is(err, errAlreadyApplied) {
+		return nil
+	}
+	return err
+}
+
+// zoneLineMatches reports whether a Joker zone line belongs to the
+// label/type RRset.
+func zoneLineMatches(line, label, rtype, zone string) bool {
+	if strings.HasPrefix(line, "$") || isZoneComment(line) {
+		return false
+	}
+	fields := splitZoneFields(line)
+	if len(fields) < 2 {
+		return false
+	}
+	return strings.EqualFold(labelRelativeToZone(fields[0], zone), label) &&
+		strings.EqualFold(fields[1], rtype)
+}
+
+// isZoneComment reports whether a zone line is a comment, which zone
+// rewrites keep as is.
+func isZoneComment(line string) bool {
+	return strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#")
+}
+
+// formatZoneLine renders one record value as a Joker zone line.
+func formatZoneLine(label, rtype, value string, ttl int) (string, error) {
+	pri := "0"
+	target := value
+
+	switch rtype {
+	case "MX":
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			return "", fmt.Errorf("malformed MX value %q; expected 'priority target'", value)
+		}
+		if _, err := strconv.ParseUint(fields[0], 10, 16); err != nil {
+			return "", fmt.Errorf("invalid MX priority %q: %w", fields[0], err)
+		}
+		pri, target = fields[0], fields[1]
+
+	case "TXT":
+		// Longer values become several character-strings, which parseZone
+		// joins again
+		target = quoteZoneString(value)
+		if len(value) > maxTXTChunk {
+			target = chunkTXT(value)
+		}
+
+	case "SRV":
+		// Joker stores SRV as pri "priority/weight", target "host:port"
+		fields := strings.Fields(value)
+		if len(fields) != 4 {
+			return "", fmt.Errorf("malformed SRV value %q; expected 'priority weight port target'", value)
+		}
+		for i, name := range []string{"priority", "weight", "port"} {
+			if _, err := strconv.ParseUint(fields[i], 10, 16); err != nil {
+				return "", fmt.Errorf("invalid SRV %s %q: %w", name, fields[i], err)
+			}
+		}
+		pri = fields[0] + "/" + fields[1]
+		target = fields[3] + ":" + fields[2]
+
+	case "CAA":
+		// Joker stores CAA as pri flags, quoted target value and the
+		// tag as the parameter after valid-from/valid-to
+		fields := splitZoneFields(value)
+		if len(fields) != 3 {
+			return "", fmt.Errorf(`malformed CAA value %q; expected 'flags tag "value"'`, value)
+		}
+		if _, err := strconv.ParseUint(fields[0], 10, 8); err != nil {
+			return "", fmt.Errorf("invalid CAA flags %q: %w", fields[0], err)
+		}
+		if !validCAATag(fields[1]) {
+			return "", fmt.Errorf("invalid CAA tag %q", fields[1])
+		}
+		return fmt.Sprintf("%s CAA %s %s %d 0 0 %s", label, fields[0], quoteZoneString(fields[2]), ttl, fields[1]), nil
+	}
+
+	return fmt.Sprintf("%s %s %s %s %d", label, rtype, pri, target, ttl), nil
+}
+
+// quoteZoneString double quotes s for a zone line, escaping quotes and
+// backslashes.
+func quoteZoneString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// validCAATag reports whether tag is a syntactically valid CAA property
+// tag (RFC 8659: ASCII letters and digits).
+func validCAATag(tag string) bool {
+	if tag == "" {
+		return false
+	}
+	for _, r := range tag {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDMAPIResponse splits a DMAPI reply into its headers and body. Header
+// names are canonicalized ("status-code" becomes "Status-Code") and repeated
+// headers, such as several Error lines, are joined with "; ". A reply
+// without a blank line is all headers.
+func parseDMAPIResponse(raw string) *dmapiResponse {
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	raw = strings.TrimLeft(raw, "\n")
+
+	head, body, _ := strings.Cut(raw, "\n\n")
+
+	resp := &dmapiResponse{
+		headers: make(map[string]string),
+		body:    body,
+	}
+	for _, line := range strings.Split(head, "\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		k = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		if prev, dup := resp.headers[k]; dup && prev != "" {
+			v = prev + "; " + v
+		}
+		resp.headers[k] = v
+	}
+	return resp
+}
+
+// columns returns the column names announced by a tabular reply's Columns
+// header, if any.
+func (r *dmapiResponse) columns() []string {
+	var cols []string
+	for _, c := range strings.Split(r.headers["Columns"], ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// rows splits a tabular reply's body into the fields of each non-empty
+// line. Fields are tab separated when the Separator header says so and
+// whitespace separated otherwise.
+func (r *dmapiResponse) rows() [][]string {
+	tab := strings.EqualFold(r.headers["Separator"], "tab")
+
+	var rows [][]string
+	for _, line := range strings.Split(r.body, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if tab {
+			rows = append(rows, strings.Split(line, "\t"))
+		} else {
+			rows = append(rows, strings.Fields(line))
+		}
+	}
+	return rows
+}
+
+// parseZone converts a Joker zone listing into libdns records. Each line
+// has the form:
+//
+//	<label> <type> <pri> <target> [<ttl> [<valid-from> <valid-to> <params>]]
+//
+// A TXT target may be several quoted strings, which are joined. A line
+// without a TTL (or an empty one) gets the zone's $TTL if it has one,
+// else defTTL, the TTL AppendRecords writes by default. Other directives
+// ($dyndns, ...), blank lines and comment lines (";" or "#") are skipped.
+// Joker has no per-record comments, so there are none to return.
+func parseZone(body, zone string, defTTL time.Duration) ([]libdns.Record, error) {
+	var records []libdns.Record
+
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || isZoneComment(line) {
+			continue
+		}
+		if strings.HasPrefix(line, "$") {
+			if fields := strings.Fields(line); strings.EqualFold(fields[0], "$TTL") && len(fields) > 1 {
+				ttl, err := parseZoneTTL(fields[1])
+				if err != nil {
+					return nil, fmt.Errorf("malformed $TTL %q: %w", line, err)
+				}
+				defTTL = ttl
+			}
+			continue
+		}
+
+		fields, quoted := splitZoneFieldsQuoted(line)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("malformed zone line %q", line)
+		}
+
+		label, rtype, pri, target := fields[0], strings.ToUpper(fields[1]), fields[2], fields[3]
+		if rtype == "TXT" {
+			// A long value is listed as consecutive quoted strings
+			n := 4
+			for n < len(fields) && quoted[n] {
+				target += fields[n]
+				n++
+			}
+			fields = append(fields[:4], fields[n:]...)
+		}
+
+		ttl := defTTL
+		if len(fields) > 4 && fields[4] != "" {
+			var err error
+			if ttl, err = parseZoneTTL(fields[4]); err != nil {
+				return nil, fmt.Errorf("malformed TTL in zone line %q: %w", line, err)
+			}
+		}
+
+		data := target
+		switch rtype {
+		case "MX":
+			data = pri + " " + target
+		case "SRV":
+			// pri is "priority/weight", target is "host:port"
+			prio, weight, _ := strings.Cut(pri, "/")
+			host, port, _ := strings.Cut(target, ":")
+			data = fmt.Sprintf("%s %s %s %s", prio, weight, port, host)
+		case "CAA":
+			// pri is the flags, the tag follows valid-from/valid-to
+			if len(fields) < 8 {
+				return nil, fmt.Errorf("malformed CAA zone line %q", line)
+			}
+			data = fmt.Sprintf("%s %s %q", pri, fields[7], target)
+		}
+
+		records = append(records, libdns.RR{
+			Name: labelRelativeToZone(label, zone),
+			Type: rtype,
+			TTL:  ttl,
+			Data: data,
+		})
+	}
+
+	return records, nil
+}
+
+// parseZoneTTL parses a TTL in whole seconds, as Joker lists it.
+func parseZoneTTL(s string) (time.Duration, error) {
+	secs, err := strconv.ParseUint(s, 10, 31)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// splitZoneFields splits a zone line on whitespace, keeping double
+// quoted strings together and removing their quotes and escapes.
+func splitZoneFields(line string) []string {
+	fields, _ := splitZoneFieldsQuoted(line)
+	return fields
+}
+
+// splitZoneFieldsQuoted is splitZoneFields, also reporting for each field
+// whether it was quoted.
+func splitZoneFieldsQuoted(line string) ([]string, []bool) {
+	var (
+		fields    []string
+		wasQuoted []bool
+		cur       strings.Builder
+		quoted    bool
+		escaped   bool
+		inTok     bool
+		tokQuoted bool
+	)
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+			inTok, tokQuoted = true, true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inTok {
+				fields = append(fields, cur.String())
+				wasQuoted = append(wasQuoted, tokQuoted)
+				cur.Reset()
+				inTok, tokQuoted = false, false
+			}
+		default:
+			cur.WriteRune(r)
+			inTok = true
+		}
+	}
+	if inTok {
+		fields = append(fields, cur.String())
+		wasQuoted = append(wasQuoted, tokQuoted)
+	}
+	return fields, wasQuoted
+}
@@ -0,0 +1,633 @@
+package joker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libdns/libdns"
+)
+
+func TestGetRecords(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com",
+		"@ A 0 192.0.2.1 3600",
+		"www A 0 192.0.2.2 300",
+		"www AAAA 0 2001:db8::2 300",
+		`_acme-challenge TXT 0 "token" 60`,
+		"mail CNAME 0 mx.example.net 86400",
+	)
+	c := newTestClient(t, f)
+
+	records, err := c.GetRecords(context.Background(), "example.com")
+	require.NoError(t, err)
+
+	assert.Equal(t, []libdns.Record{
+		libdns.Address{Name: "@", TTL: time.Hour, IP: netip.MustParseAddr("192.0.2.1")},
+		libdns.Address{Name: "www", TTL: 5 * time.Minute, IP: netip.MustParseAddr("192.0.2.2")},
+		libdns.Address{Name: "www", TTL: 5 * time.Minute, IP: netip.MustParseAddr("2001:db8::2")},
+		libdns.TXT{Name: "_acme-challenge", TTL: time.Minute, Text: "token"},
+		libdns.CNAME{Name: "mail", TTL: 24 * time.Hour, Target: "mx.example.net"},
+	}, records)
+
+	gets := f.received(dmapiPath + "/dns-zone-get")
+	require.Len(t, gets, 1)
+	assert.Equal(t, "example.com", gets[0].Form.Get("domain"))
+	assert.Equal(t, "sid-1", gets[0].Form.Get("auth-sid"))
+}
+
+func TestAppendMX(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com", "www A 0 192.0.2.1 300")
+	c := newTestClient(t, f)
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.MX{Name: "@", TTL: time.Hour, Preference: 10, Target: "mail.example.com"},
+	})
+	require.NoError(t, err)
+
+	assert.Empty(t, f.received(nicReplacePath), "MX must not go through /nic/replace")
+	assert.Equal(t, []string{
+		"www A 0 192.0.2.1 300",
+		"@ MX 10 mail.example.com 3600",
+	}, f.zone("example.com"))
+
+	records, err := c.GetRecords(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Contains(t, records, libdns.Record(
+		libdns.MX{Name: "@", TTL: time.Hour, Preference: 10, Target: "mail.example.com"},
+	))
+}
+
+func TestFormatZoneLineMalformedMX(t *testing.T) {
+	for _, value := range []string{"mail.example.com", "x mail.example.com", "70000 mail.example.com"} {
+		_, err := formatZoneLine("@", "MX", value, 3600)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestAppendSRV(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	srv := libdns.SRV{
+		Service:   "sip",
+		Transport: "tcp",
+		Name:      "@",
+		TTL:       time.Hour,
+		Priority:  10,
+		Weight:    60,
+		Port:      5060,
+		Target:    "sip.example.com",
+	}
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{srv})
+	require.NoError(t, err)
+
+	assert.Empty(t, f.received(nicReplacePath))
+	assert.Equal(t, []string{"_sip._tcp SRV 10/60 sip.example.com:5060 3600"}, f.zone("example.com"))
+
+	records, err := c.GetRecords(context.Background(), "example.com")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, srv, records[0])
+}
+
+func TestFormatZoneLineMalformedSRV(t *testing.T) {
+	for _, value := range []string{
+		"10 60 sip.example.com",
+		"10 60 5060 sip.example.com extra",
+		"x 60 5060 sip.example.com",
+		"10 x 5060 sip.example.com",
+		"10 60 99999 sip.example.com",
+	} {
+		_, err := formatZoneLine("_sip._tcp", "SRV", value, 3600)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestAppendCAA(t *testing.T) {
+	tests := []struct {
+		tag   string
+		value string
+		line  string
+	}{
+		{tag: "issue", value: "letsencrypt.org", line: `@ CAA 0 "letsencrypt.org" 3600 0 0 issue`},
+		{tag: "issuewild", value: ";", line: `@ CAA 0 ";" 3600 0 0 issuewild`},
+		{tag: "iodef", value: "mailto:security@example.com", line: `@ CAA 0 "mailto:security@example.com" 3600 0 0 iodef`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			c := newTestClient(t, f)
+
+			caa := libdns.CAA{Name: "@", TTL: time.Hour, Tag: tt.tag, Value: tt.value}
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{caa})
+			require.NoError(t, err)
+			assert.Equal(t, []string{tt.line}, f.zone("example.com"))
+
+			records, err := c.GetRecords(context.Background(), "example.com")
+			require.NoError(t, err)
+			require.Len(t, records, 1)
+			assert.Equal(t, caa, records[0])
+		})
+	}
+}
+
+func TestFormatZoneLineMalformedCAA(t *testing.T) {
+	for _, value := range []string{
+		`0 issue`,
+		`x issue "letsencrypt.org"`,
+		`0 is-sue "letsencrypt.org"`,
+	} {
+		_, err := formatZoneLine("@", "CAA", value, 3600)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseZoneMultiStringTXT(t *testing.T) {
+	records, err := parseZone(strings.Join([]string{
+		`_dkim TXT 0 "aaa" "bbb" 300`,
+		`one TXT 0 "single" 60 0 0`,
+		`bare TXT 0 "no ttl"`,
+	}, "\n"), "example.com", time.Hour)
+	require.NoError(t, err)
+
+	assert.Equal(t, []libdns.Record{
+		libdns.RR{Name: "_dkim", Type: "TXT", TTL: 5 * time.Minute, Data: "aaabbb"},
+		libdns.RR{Name: "one", Type: "TXT", TTL: time.Minute, Data: "single"},
+		libdns.RR{Name: "bare", Type: "TXT", TTL: time.Hour, Data: "no ttl"},
+	}, records)
+}
+
+func TestParseZoneTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    time.Duration
+		wantErr string
+	}{
+		{name: "numeric", body: "www A 0 192.0.2.1 300", want: 5 * time.Minute},
+		{name: "zero", body: "www A 0 192.0.2.1 0", want: 0},
+		{name: "missing", body: "www A 0 192.0.2.1", want: time.Hour},
+		{name: "$TTL default", body: "$TTL 600\nwww A 0 192.0.2.1", want: 10 * time.Minute},
+		{name: "unit suffix", body: "www A 0 192.0.2.1 5m", wantErr: "malformed TTL"},
+		{name: "negative", body: "www A 0 192.0.2.1 -1", wantErr: "malformed TTL"},
+		{name: "too large", body: "www A 0 192.0.2.1 4294967296", wantErr: "malformed TTL"},
+		{name: "malformed $TTL", body: "$TTL soon\nwww A 0 192.0.2.1", wantErr: "malformed $TTL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := parseZone(tt.body, "example.com", time.Hour)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, records, 1)
+			assert.Equal(t, tt.want, records[0].RR().TTL)
+		})
+	}
+}
+
+func TestTTLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{name: "given", ttl: 5 * time.Minute, want: 5 * time.Minute},
+		{name: "default", want: defaultTTL},
+		{name: "clamped", ttl: 10 * time.Second, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			c := newTestClient(t, f)
+
+			added, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", TTL: tt.ttl, Text: "token"},
+			})
+			require.NoError(t, err)
+			require.Len(t, added, 1)
+			assert.Equal(t, tt.want, added[0].RR().TTL)
+
+			records, err := c.GetRecords(context.Background(), "example.com")
+			require.NoError(t, err)
+			require.Len(t, records, 1)
+			assert.Equal(t, tt.want, records[0].RR().TTL)
+		})
+	}
+}
+
+func TestZoneComments(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com",
+		"; managed by hand",
+		"www A 0 192.0.2.1 300",
+		"# keep this",
+	)
+	c := newTestClient(t, f, func(c *Client) {
+		c.Mode = modeDMAPI
+	})
+
+	records, err := c.GetRecords(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []libdns.Record{
+		libdns.Address{Name: "www", TTL: 5 * time.Minute, IP: netip.MustParseAddr("192.0.2.1")},
+	}, records)
+
+	_, err = c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", TTL: 5 * time.Minute, Text: "token"},
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"; managed by hand",
+		"www A 0 192.0.2.1 300",
+		"# keep this",
+		`_acme-challenge TXT 0 "token" 300`,
+	}, f.zone("example.com"))
+}
+
+func TestGetRecord(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com",
+		"www A 0 192.0.2.1 300",
+		"www AAAA 0 2001:db8::1 300",
+		`_acme-challenge TXT 0 "token" 60`,
+	)
+	c := newTestClient(t, f)
+
+	tests := []struct {
+		name      string
+		zone      string
+		rname     string
+		rtype     string
+		want      libdns.Record
+		wantFound bool
+	}{
+		{
+			name: "found", zone: "example.com", rname: "www", rtype: "AAAA",
+			want:      libdns.Address{Name: "www", TTL: 5 * time.Minute, IP: netip.MustParseAddr("2001:db8::1")},
+			wantFound: true,
+		},
+		{
+			name: "fully qualified", zone: "", rname: "_acme-challenge.example.com.", rtype: "txt",
+			want:      libdns.TXT{Name: "_acme-challenge", TTL: time.Minute, Text: "token"},
+			wantFound: true,
+		},
+		{
+			name: "case insensitive name", zone: "example.com", rname: "WWW", rtype: "A",
+			want:      libdns.Address{Name: "www", TTL: 5 * time.Minute, IP: netip.MustParseAddr("192.0.2.1")},
+			wantFound: true,
+		},
+		{name: "other type", zone: "example.com", rname: "www", rtype: "TXT"},
+		{name: "other name", zone: "example.com", rname: "mail", rtype: "A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, found, err := c.GetRecord(context.Background(), tt.zone, tt.rname, tt.rtype)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFound, found)
+			assert.Equal(t, tt.want, rec)
+		})
+	}
+}
+
+func TestGetRecordErrors(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	_, _, err := c.GetRecord(context.Background(), "example.com", "www.example.org.", "A")
+	assert.ErrorContains(t, err, "www.example.org.")
+	assert.Empty(t, f.received(dmapiPath+"/dns-zone-get"))
+
+	_, _, err = c.GetRecord(context.Background(), "", "www.example.org.", "A")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, _, err = c.GetRecord(context.Background(), "example.net", "www", "A")
+	assert.ErrorContains(t, err, "Object does not exist")
+}
+
+func TestAppendLongTXTViaDMAPI(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f, func(c *Client) {
+		c.Mode = modeDMAPI
+	})
+
+	dkim := "v=DKIM1; k=rsa; p=" + strings.Repeat("A", 282)
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "sel._domainkey", Text: dkim},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`sel._domainkey TXT 0 "` + dkim[:255] + `" "` + dkim[255:] + `" 3600`,
+	}, f.zone("example.com"))
+
+	records, err := c.GetRecords(context.Background(), "example.com")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, dkim, records[0].(libdns.TXT).Text)
+	assert.Equal(t, time.Hour, records[0].(libdns.TXT).TTL)
+}
+
+func TestListZones(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.setZone("example.org")
+	c := newTestClient(t, f)
+
+	zones, err := c.ListZones(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []libdns.Zone{{Name: "example.com."}, {Name: "example.org."}}, zones)
+
+	logins := f.received(dmapiPath + "/login")
+	require.Len(t, logins, 1)
+	assert.Equal(t, "secret-token", logins[0].Form.Get("api-key"))
+	lists := f.received(dmapiPath + "/query-domain-list")
+	require.Len(t, lists, 1)
+	assert.Equal(t, "sid-1", lists[0].Form.Get("auth-sid"))
+}
+
+func TestListZonesColumns(t *testing.T) {
+	f := newFakeJoker(t)
+	f.dmapi = func(w http.ResponseWriter, cmd string, form url.Values) bool {
+		if cmd != "query-domain-list" {
+			return false
+		}
+		fmt.Fprint(w, "Status-Code: 0\nColumns: expiration,domain\nSeparator: tab\n\n"+
+			"2030-01-01\texample.com\n2031-01-01\tEXAMPLE.net\n")
+		return true
+	}
+	c := newTestClient(t, f)
+
+	zones, err := c.ListZones(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []libdns.Zone{{Name: "example.com."}, {Name: "example.net."}}, zones)
+}
+
+func TestDMAPIMode(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f, func(c *Client) {
+		c.Mode = modeDMAPI
+		c.APIToken = ""
+		c.Username = "alice"
+		c.Password = "s3cret"
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.Address{Name: "www", TTL: 5 * time.Minute, IP: netip.MustParseAddr("192.0.2.1")},
+	})
+	require.NoError(t, err)
+	_, err = c.GetRecords(context.Background(), "example.com")
+	require.NoError(t, err)
+
+	assert.Empty(t, f.received(nicReplacePath))
+	assert.Equal(t, []string{"www A 0 192.0.2.1 300"}, f.zone("example.com"))
+
+	// One login, reused by every later command
+	logins := f.received(dmapiPath + "/login")
+	require.Len(t, logins, 1)
+	assert.Equal(t, "alice", logins[0].Form.Get("username"))
+	assert.Equal(t, "s3cret", logins[0].Form.Get("password"))
+	for _, cmd := range []string{"dns-zone-get", "dns-zone-put"} {
+		reqs := f.received(dmapiPath + "/" + cmd)
+		require.NotEmpty(t, reqs, cmd)
+		for _, r := range reqs {
+			assert.Equal(t, "sid-1", r.Form.Get("auth-sid"), cmd)
+		}
+	}
+}
+
+func TestDMAPILoginFailure(t *testing.T) {
+	f := newFakeJoker(t)
+	f.dmapi = func(w http.ResponseWriter, cmd string, form url.Values) bool {
+		fmt.Fprint(w, "Status-Code: 2200\nStatus-Text: Authorization error\n\n")
+		return true
+	}
+	c := newTestClient(t, f)
+
+	_, err := c.GetRecords(context.Background(), "example.com")
+	assert.ErrorIs(t, err, ErrAuth)
+}
+
+func TestDMAPISessionReuse(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	clk := newFakeClock()
+	c := newTestClient(t, f, func(c *Client) {
+		c.clock = clk
+	})
+
+	for range 3 {
+		_, err := c.GetRecords(context.Background(), "example.com")
+		require.NoError(t, err)
+	}
+	assert.Len(t, f.received(dmapiPath+"/login"), 1)
+
+	// A session the server rejects is replaced, and the command retried
+	var rejected atomic.Bool
+	f.dmapi = func(w http.ResponseWriter, cmd string, form url.Values) bool {
+		if cmd == "dns-zone-get" && rejected.CompareAndSwap(false, true) {
+			http.Error(w, "Status-Code: 2200\nStatus-Text: Session expired\n\n", http.StatusUnauthorized)
+			return true
+		}
+		return false
+	}
+	_, err := c.GetRecords(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Len(t, f.received(dmapiPath+"/login"), 2)
+	assert.Len(t, f.received(dmapiPath+"/dns-zone-get"), 5)
+
+	// So is one that has reached its lifetime
+	clk.advance(dmapiSessionLifetime)
+	_, err = c.GetRecords(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Len(t, f.received(dmapiPath+"/login"), 3)
+}
+
+func TestDMAPISessionConcurrentLogin(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.GetRecords(context.Background(), "example.com")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, f.received(dmapiPath+"/login"), 1)
+}
+
+func TestVerifyCredentials(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	// A cached session doesn't stand in for a fresh login
+	_, err := c.GetRecords(context.Background(), "example.com")
+	require.NoError(t, err)
+	require.NoError(t, c.VerifyCredentials(context.Background()))
+	assert.Len(t, f.received(dmapiPath+"/login"), 2)
+	assert.Empty(t, f.received(nicReplacePath))
+}
+
+func TestVerifyCredentialsBadAuth(t *testing.T) {
+	f := newFakeJoker(t)
+	f.dmapi = func(w http.ResponseWriter, cmd string, form url.Values) bool {
+		if cmd != "login" || form.Get("api-key") != "bad-token" {
+			return false
+		}
+		fmt.Fprint(w, "Status-Code: 2200\nStatus-Text: Authorization error\n\n")
+		return true
+	}
+
+	t.Run("default", func(t *testing.T) {
+		c := newTestClient(t, f, func(c *Client) {
+			c.APIToken = "bad-token"
+		})
+		assert.ErrorIs(t, c.VerifyCredentials(context.Background()), ErrAuth)
+	})
+
+	t.Run("zone", func(t *testing.T) {
+		c := newTestClient(t, f, func(c *Client) {
+			c.ZoneCredentials = map[string]Credential{
+				"example.org": {APIToken: "bad-token"},
+			}
+		})
+		err := c.VerifyCredentials(context.Background())
+		assert.ErrorIs(t, err, ErrAuth)
+		assert.ErrorContains(t, err, "zone_credentials example.org")
+	})
+}
+
+func TestParseDMAPIResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		headers map[string]string
+		body    string
+		columns []string
+		rows    [][]string
+	}{
+		{
+			name: "login",
+			raw: "Auth-Sid: 3f6c1e0a9b\r\nUID: 12345\r\nTracking-Id: 987654\r\nStatus-Code: 0\r\n" +
+				"Status-Text: Command completed successfully\r\nAccount-Balance: 10.00\r\n\r\n" +
+				"com\r\nnet\r\n",
+			headers: map[string]string{
+				"Auth-Sid":        "3f6c1e0a9b",
+				"Uid":             "12345",
+				"Tracking-Id":     "987654",
+				"Status-Code":     "0",
+				"Status-Text":     "Command completed successfully",
+				"Account-Balance": "10.00",
+			},
+			body: "com\nnet\n",
+			rows: [][]string{{"com"}, {"net"}},
+		},
+		{
+			name: "dns-zone-get",
+			raw: "Tracking-Id: 987655\nStatus-Code: 0\nStatus-Text: Command completed successfully\n\n" +
+				"$dyndns=no\n@ A 0 192.0.2.1 86400\nwww CNAME 0 example.com. 86400\n",
+			headers: map[string]string{
+				"Tracking-Id": "987655",
+				"Status-Code": "0",
+				"Status-Text": "Command completed successfully",
+			},
+			body: "$dyndns=no\n@ A 0 192.0.2.1 86400\nwww CNAME 0 example.com. 86400\n",
+			rows: [][]string{
+				{"$dyndns=no"},
+				{"@", "A", "0", "192.0.2.1", "86400"},
+				{"www", "CNAME", "0", "example.com.", "86400"},
+			},
+		},
+		{
+			name: "query-domain-list columns",
+			raw: "Status-Code: 0\nStatus-Text: OK\nColumns: domain,expiration\nSeparator: tab\n\n" +
+				"example.com\t2030-01-01\nexample.org\t2031-06-30\n",
+			headers: map[string]string{
+				"Status-Code": "0",
+				"Status-Text": "OK",
+				"Columns":     "domain,expiration",
+				"Separator":   "tab",
+			},
+			body:    "example.com\t2030-01-01\nexample.org\t2031-06-30\n",
+			columns: []string{"domain", "expiration"},
+			rows:    [][]string{{"example.com", "2030-01-01"}, {"example.org", "2031-06-30"}},
+		},
+		{
+			name: "error",
+			raw: "status-code: 2303\nstatus-text: Object does not exist\n" +
+				"Error: domain example.net not found\nError: check the domain name\n",
+			headers: map[string]string{
+				"Status-Code": "2303",
+				"Status-Text": "Object does not exist",
+				"Error":       "domain example.net not found; check the domain name",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := parseDMAPIResponse(tt.raw)
+			assert.Equal(t, tt.headers, resp.headers)
+			assert.Equal(t, tt.body, resp.body)
+			assert.Equal(t, tt.columns, resp.columns())
+			assert.Equal(t, tt.rows, resp.rows())
+		})
+	}
+}
+
+func TestDMAPIDeleteRemovesLines(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com",
+		"@ A 0 192.0.2.1 3600",
+		`_acme-challenge TXT 0 "token" 300`,
+		`_acme-challenge TXT 0 "other" 300`,
+		"www A 0 192.0.2.2 3600",
+	)
+	c := newTestClient(t, f, func(c *Client) {
+		c.Mode = modeDMAPI
+	})
+
+	deleted, err := c.DeleteRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+		libdns.Address{Name: "www", IP: netip.MustParseAddr("192.0.2.2")},
+	})
+	require.NoError(t, err)
+	assert.Len(t, deleted, 2)
+
+	assert.Empty(t, f.received(nicReplacePath))
+	assert.NotEmpty(t, f.received(dmapiPath+"/dns-zone-put"))
+
+	// The lines are gone, not left behind with empty values
+	assert.ElementsMatch(t, []string{
+		"@ A 0 192.0.2.1 3600",
+		`_acme-challenge TXT 0 "other" 300`,
+	}, f.zone("example.com"))
+}
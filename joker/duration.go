@@ -0,0 +1,61 @@
+package joker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that decodes from JSON either as
+// nanoseconds or as a string such as "90s" or "1d", like the durations in
+// a Caddy config.
+type Duration time.Duration
+
+// UnmarshalJSON accepts a number of nanoseconds or a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var ns time.Duration
+		if err := json.Unmarshal(b, &ns); err != nil {
+			return err
+		}
+		*d = Duration(ns)
+		return nil
+	}
+
+	dur, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(dur)
+	return nil
+}
+
+// ParseDuration parses a duration string as time.ParseDuration does, with
+// "d" (24h) accepted as a unit too.
+func ParseDuration(s string) (time.Duration, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != 'd' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		// Rewrite the number before the "d" in hours
+		start := i
+		for start > 0 && (s[start-1] == '.' || s[start-1] >= '0' && s[start-1] <= '9') {
+			start--
+		}
+		days, err := strconv.ParseFloat(s[start:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		prefix := b.String()[:b.Len()-(i-start)]
+		b.Reset()
+		b.WriteString(prefix)
+		b.WriteString(strconv.FormatFloat(days*24, 'f', -1, 64) + "h")
+	}
+	return time.ParseDuration(b.String())
+}
@@ -0,0 +1,64 @@
+package joker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libdns/libdns"
+)
+
+func TestDurationJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: `"90s"`, want: 90 * time.Second},
+		{in: `"1d"`, want: 24 * time.Hour},
+		{in: `"1.5d2h"`, want: 38 * time.Hour},
+		{in: `60000000000`, want: time.Minute},
+		{in: `"soon"`, wantErr: true},
+		{in: `true`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var v struct {
+				TTL Duration `json:"ttl"`
+			}
+			err := json.Unmarshal([]byte(`{"ttl":`+tt.in+`}`), &v)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, Duration(tt.want), v.TTL)
+		})
+	}
+}
+
+func TestClientFromJSON(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+
+	var c Client
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"api_token": "secret-token",
+		"endpoint": "`+f.srv.URL+nicReplacePath+`",
+		"dmapi_endpoint": "`+f.srv.URL+dmapiPath+`",
+		"default_ttl": "10m"
+	}`), &c))
+	require.NoError(t, c.Setup())
+	require.NoError(t, c.Validate())
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`_acme-challenge TXT 0 "token" 600`}, f.zone("example.com"))
+}
@@ -0,0 +1,184 @@
+package joker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/libdns/libdns"
+)
+
+// Default IP echo services, answering a GET with the caller's public
+// IPv4 or IPv6 address in plain text.
+const (
+	defaultIPDetectURL   = "https://ipv4.icanhazip.com"
+	defaultIPv6DetectURL = "https://ipv6.icanhazip.com"
+)
+
+// UpdateDynamicIP points the A (IPv4) or AAAA (IPv6) record of hostname,
+// e.g. "home.example.com", at ip, replacing its previous address. If ip is
+// the zero Addr the public IPv4 address of this host is detected and used. The
+// zone is found among the account's domains and ZoneCredentials. It
+// returns the address set.
+func (c *Client) UpdateDynamicIP(ctx context.Context, hostname string, ip netip.Addr) (netip.Addr, error) {
+	ctx = withRequestID(ctx)
+
+	if !ip.IsValid() {
+		detected, err := c.DetectPublicIP(ctx, false)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		ip = detected
+	}
+	ip = ip.Unmap()
+
+	zone, err := c.zoneOf(ctx, hostname)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	rec := libdns.Address{
+		Name: labelRelativeToZone(hostname, zone),
+		IP:   ip,
+	}
+	if _, err := c.SetRecords(ctx, zone, []libdns.Record{rec}); ignoreNoChange(err) != nil {
+		return netip.Addr{}, err
+	}
+	return ip, nil
+}
+
+// zoneOf returns the zone that hostname belongs to among candidateZones,
+// preferring the longest match.
+func (c *Client) zoneOf(ctx context.Context, hostname string) (string, error) {
+	zones, listErr := c.candidateZones(ctx)
+	zone, err := matchZone(zones, hostname)
+	if err != nil && listErr != nil {
+		return "", fmt.Errorf("finding zone of %q: %w", hostname, listErr)
+	}
+	return zone, err
+}
+
+// candidateZones returns the zones a fully qualified name may belong to:
+// the account's domains and the zones of ZoneCredentials, which may be in
+// other accounts. If listing the account's domains fails, the error comes
+// with the ZoneCredentials zones, which may still match.
+func (c *Client) candidateZones(ctx context.Context) ([]libdns.Zone, error) {
+	zones, err := c.ListZones(ctx)
+	for zone := range c.zoneAuths {
+		zones = append(zones, libdns.Zone{Name: zone + "."})
+	}
+	return zones, err
+}
+
+// matchZone returns the zone among zones that hostname belongs to,
+// preferring the longest match. Names are compared in punycode.
+func matchZone(zones []libdns.Zone, hostname string) (string, error) {
+	name := asciiOrSelf(normalizeZone(hostname))
+	best := ""
+	for _, z := range zones {
+		zone := asciiOrSelf(normalizeZone(z.Name))
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("%w: no zone in the account contains %q", ErrNotFound, hostname)
+	}
+	return best, nil
+}
+
+// DetectPublicIP asks the IPDetectURL (or, for ipv6, IPv6DetectURL) echo
+// service for this host's public address, retrying like Joker requests.
+func (c *Client) DetectPublicIP(ctx context.Context, ipv6 bool) (netip.Addr, error) {
+	endpoint := c.IPDetectURL
+	if endpoint == "" {
+		endpoint = defaultIPDetectURL
+	}
+	if ipv6 {
+		endpoint = c.IPv6DetectURL
+		if endpoint == "" {
+			endpoint = defaultIPv6DetectURL
+		}
+	}
+
+	res, err := c.withRetry(ctx, func() (*httpResult, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", c.UserAgent)
+
+		resp, err := c.httpClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &httpResult{status: resp.StatusCode, header: resp.Header, body: body}, err
+	})
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("detecting public IP: %w", err)
+	}
+	body := strings.TrimSpace(string(res.body))
+	if res.status != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("detecting public IP: %w", newStatusError(res.status, body))
+	}
+
+	ip, err := netip.ParseAddr(body)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("detecting public IP: unexpected response %q from %s", body, endpoint)
+	}
+	ip = ip.Unmap()
+	if ip.Is6() != ipv6 {
+		return netip.Addr{}, fmt.Errorf("detecting public IP: %s returned %s, wrong address family", endpoint, ip)
+	}
+
+	c.log(ctx).Debug("detected public IP", zap.Stringer("ip", ip))
+	return ip, nil
+}
+
+// perZone runs op once per zone for records whose names are absolute and
+// whose zone wasn't given, finding each record's zone among
+// candidateZones.
+func perZone[T any](
+	ctx context.Context,
+	c *Client,
+	records []libdns.Record,
+	op func(context.Context, string, []libdns.Record) ([]T, error),
+) ([]T, error) {
+	zones, listErr := c.candidateZones(ctx)
+
+	var (
+		order  []string
+		byZone = make(map[string][]libdns.Record)
+	)
+	for _, rec := range records {
+		zone, err := matchZone(zones, rec.RR().Name)
+		if err != nil && listErr != nil {
+			return nil, fmt.Errorf("finding zones of records: %w", listErr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := byZone[zone]; !ok {
+			order = append(order, zone)
+		}
+		byZone[zone] = append(byZone[zone], rec)
+	}
+
+	var done []T
+	for _, zone := range order {
+		recs, err := op(ctx, zone, byZone[zone])
+		done = append(done, recs...)
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
@@ -0,0 +1,139 @@
+package joker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newIPEcho returns an IP echo service answering with body.
+func newIPEcho(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestUpdateDynamicIP(t *testing.T) {
+	tests := []struct {
+		name  string
+		ip    string
+		rtype string
+	}{
+		{name: "ipv4", ip: "192.0.2.10", rtype: "A"},
+		{name: "ipv6", ip: "2001:db8::10", rtype: "AAAA"},
+		{name: "mapped ipv4", ip: "::ffff:192.0.2.10", rtype: "A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com", "home A 0 192.0.2.1 3600")
+			c := newTestClient(t, f)
+
+			ip, err := c.UpdateDynamicIP(context.Background(), "home.example.com", netip.MustParseAddr(tt.ip))
+			require.NoError(t, err)
+			assert.Equal(t, netip.MustParseAddr(tt.ip).Unmap(), ip)
+
+			reqs := f.received(nicReplacePath)
+			require.Len(t, reqs, 1)
+			assert.Equal(t, "example.com", reqs[0].Form.Get("zone"))
+			assert.Equal(t, "home", reqs[0].Form.Get("label"))
+			assert.Equal(t, tt.rtype, reqs[0].Form.Get("type"))
+			assert.Equal(t, ip.String(), reqs[0].Form.Get("value"))
+		})
+	}
+}
+
+func TestUpdateDynamicIPDetects(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	echo := newIPEcho(t, "198.51.100.7")
+	c := newTestClient(t, f, func(c *Client) {
+		c.IPDetectURL = echo.URL
+	})
+
+	ip, err := c.UpdateDynamicIP(context.Background(), "home.example.com.", netip.Addr{})
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("198.51.100.7"), ip)
+	assert.Equal(t, []string{"home A 0 198.51.100.7 3600"}, f.zone("example.com"))
+}
+
+func TestUpdateDynamicIPUnknownZone(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	_, err := c.UpdateDynamicIP(context.Background(), "home.example.org", netip.MustParseAddr("192.0.2.10"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Empty(t, f.received(nicReplacePath))
+}
+
+func TestDetectPublicIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		ipv6    bool
+		want    string
+		wantErr string
+	}{
+		{name: "ipv4", body: "198.51.100.7", want: "198.51.100.7"},
+		{name: "ipv6", body: "2001:db8::7", ipv6: true, want: "2001:db8::7"},
+		{name: "mapped ipv4", body: "::ffff:198.51.100.7", want: "198.51.100.7"},
+		{name: "wrong family", body: "2001:db8::7", wantErr: "wrong address family"},
+		{name: "garbage", body: "<html>hello</html>", wantErr: "unexpected response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			echo := newIPEcho(t, tt.body)
+			c := newTestClient(t, newFakeJoker(t), func(c *Client) {
+				if tt.ipv6 {
+					c.IPv6DetectURL = echo.URL
+				} else {
+					c.IPDetectURL = echo.URL
+				}
+			})
+
+			ip, err := c.DetectPublicIP(context.Background(), tt.ipv6)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, netip.MustParseAddr(tt.want), ip)
+		})
+	}
+}
+
+func TestDetectPublicIPRetries(t *testing.T) {
+	var calls atomic.Int32
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "198.51.100.7")
+	}))
+	t.Cleanup(echo.Close)
+	c := newTestClient(t, newFakeJoker(t), func(c *Client) {
+		c.IPDetectURL = echo.URL
+		c.MaxRetries = 0 // the default, 3
+		c.clock = newFakeClock()
+	})
+
+	ip, err := c.DetectPublicIP(context.Background(), false)
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("198.51.100.7"), ip)
+	assert.Equal(t, int32(2), calls.Load())
+}
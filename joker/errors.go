@@ -0,0 +1,62 @@
+package joker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Sentinel errors for the failure categories callers commonly need to
+// tell apart. Match them with errors.Is.
+var (
+	ErrAuth        = errors.New("authentication failed")
+	ErrNotFound    = errors.New("not found")
+	ErrRateLimited = errors.New("rate limited")
+
+	ErrPropagationTimeout = errors.New("timed out waiting for DNS propagation")
+
+	// ErrCircuitOpen is returned without contacting Joker while the
+	// circuit breaker is open after repeated failures.
+	ErrCircuitOpen = errors.New("joker circuit breaker open")
+
+	// ErrNoChange reports a successful write that left the records as
+	// they were. It is only returned when Client.ReportNoChange is set.
+	ErrNoChange = errors.New("no change")
+)
+
+// APIError is returned when Joker answers a request with a failure, either
+// as an HTTP status or as an error body on HTTP 200. Err, when set, is the
+// sentinel or description for the failure.
+type APIError struct {
+	StatusCode int
+	Body       string
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("joker API error: status=%d response=%s", e.StatusCode, e.Body)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// newStatusError builds an APIError for a non-success HTTP status,
+// categorising the status where possible.
+func newStatusError(status int, body string) *APIError {
+	e := &APIError{StatusCode: status, Body: body}
+
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		e.Err = ErrAuth
+	case http.StatusNotFound:
+		e.Err = ErrNotFound
+	case http.StatusTooManyRequests:
+		e.Err = ErrRateLimited
+	}
+	return e
+}
@@ -0,0 +1,104 @@
+package joker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libdns/libdns"
+)
+
+func TestAPIErrorFromReplace(t *testing.T) {
+	tests := []struct {
+		status int
+		is     error
+	}{
+		{status: http.StatusUnauthorized, is: ErrAuth},
+		{status: http.StatusForbidden, is: ErrAuth},
+		{status: http.StatusNotFound, is: ErrNotFound},
+		{status: http.StatusTooManyRequests, is: ErrRateLimited},
+		{status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			f.replace = func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "nope", tt.status)
+			}
+			c := newTestClient(t, f)
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+
+			var apiErr *APIError
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, tt.status, apiErr.StatusCode)
+			assert.Equal(t, "nope", apiErr.Body)
+			if tt.is != nil {
+				assert.ErrorIs(t, err, tt.is)
+			} else {
+				assert.NoError(t, apiErr.Err)
+			}
+		})
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	err := error(&APIError{StatusCode: http.StatusOK, Body: "badauth", Err: ErrAuth})
+	assert.Equal(t, "joker API error: status=200 response=badauth: authentication failed", err.Error())
+	assert.True(t, errors.Is(err, ErrAuth))
+}
+
+func TestGzipErrorBody(t *testing.T) {
+	const message = "zone example.com is locked"
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := zw.Write([]byte(message))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{name: "gzip", encoding: "gzip", body: compressed.Bytes()},
+		{name: "unlabelled gzip", body: compressed.Bytes()},
+		{name: "labelled but plain", encoding: "gzip", body: []byte(message)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			f.replace = func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(tt.body)
+			}
+			c := newTestClient(t, f)
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+
+			var apiErr *APIError
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, message, apiErr.Body)
+			assert.ErrorContains(t, err, message)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package joker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/libdns/libdns"
+)
+
+// errAlreadyApplied ends the retries of a write whose earlier attempt
+// turned out to have succeeded after all. Callers map it to success.
+var errAlreadyApplied = errors.New("write already applied")
+
+type writeCheckKey struct{}
+
+// writeCheck lets withRetry find out, before repeating a write whose
+// outcome is unknown, whether the first attempt went through.
+type writeCheck struct {
+	// key identifies the logical operation across attempts
+	key     string
+	applied func(context.Context) bool
+}
+
+// withWriteCheck returns ctx carrying a check that the label/type RRset of
+// zone holds exactly values at ttl (or nothing, for an empty values).
+//
+// Joker has no idempotency keys. Writes replace whole RRsets, so repeating
+// one is harmless in itself, but a repeat after a timeout can still undo a
+// concurrent change made in between, and costs a request for nothing.
+// Looking first keeps a retried write from doing either.
+func (c *Client) withWriteCheck(
+	ctx context.Context,
+	zone, label, rtype string,
+	values []string,
+	ttl int,
+) context.Context {
+	return context.WithValue(ctx, writeCheckKey{}, &writeCheck{
+		key: operationKey(zone, label, rtype, values, ttl),
+		applied: func(ctx context.Context) bool {
+			// The lookup's own requests must not consult this check
+			return c.rrsetApplied(withoutWriteCheck(ctx), zone, label, rtype, values, ttl)
+		},
+	})
+}
+
+func writeCheckFrom(ctx context.Context) *writeCheck {
+	c, _ := ctx.Value(writeCheckKey{}).(*writeCheck)
+	return c
+}
+
+// withoutWriteCheck returns ctx with any write check removed, for requests
+// made on the way to a write that are not the write itself.
+func withoutWriteCheck(ctx context.Context) context.Context {
+	if writeCheckFrom(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, writeCheckKey{}, (*writeCheck)(nil))
+}
+
+// operationKey derives a stable ID for writing values to an RRset, the
+// same for every attempt and regardless of value order.
+func operationKey(zone, label, rtype string, values []string, ttl int) string {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	h := sha256.New()
+	for _, s := range append([]string{zone, label, rtype, strconv.Itoa(ttl)}, sorted...) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// rrsetApplied reports whether the zone, as DMAPI currently reports it,
+// already holds the RRset as written. Any lookup failure counts as not
+// applied, so the write is retried as usual.
+func (c *Client) rrsetApplied(
+	ctx context.Context,
+	zone, label, rtype string,
+	values []string,
+	ttl int,
+) bool {
+	records, err := c.getRecords(ctx, zone, false)
+	if err != nil {
+		c.log(ctx).Debug("could not check whether write was applied",
+			zap.String("zone", zone),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	key := rrsetKey{zone: zone, label: label, rtype: rtype}
+	if len(values) > 0 {
+		return c.rrsetMatches(records, key, values, ttl)
+	}
+	return !slices.ContainsFunc(records, func(rec libdns.Record) bool {
+		rr := rec.RR()
+		return strings.EqualFold(rr.Type, rtype) &&
+			sameLabel(labelRelativeToZone(rr.Name, zone), label)
+	})
+}
+
+// ambiguousFailure reports whether a failed attempt may have reached
+// Joker and been applied: a network error such as a timeout, or a 5xx
+// that may have come from a proxy after Joker did the work.
+func ambiguousFailure(res *httpResult, err error) bool {
+	return err != nil || res == nil || res.status >= 500
+}
@@ -0,0 +1,166 @@
+package joker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libdns/libdns"
+)
+
+func TestRetriedWriteNotDuplicated(t *testing.T) {
+	tests := []struct {
+		name string
+		// fail ends the first request after Joker has applied it
+		fail func(w http.ResponseWriter)
+	}{
+		{
+			name: "connection dropped",
+			fail: func(w http.ResponseWriter) {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+			},
+		},
+		{
+			name: "gateway timeout",
+			fail: func(w http.ResponseWriter) {
+				http.Error(w, "upstream timed out", http.StatusGatewayTimeout)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com", `_acme-challenge TXT 0 "other" 3600`)
+			var calls atomic.Int32
+			f.replace = func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					f.serveReplace(httptest.NewRecorder(), r.Form)
+					tt.fail(w)
+					return
+				}
+				f.serveReplace(w, r.Form)
+			}
+			c := newTestClient(t, f, func(c *Client) {
+				c.MaxRetries = 0 // the default, 3
+				c.clock = newFakeClock()
+			})
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			require.NoError(t, err)
+			assert.EqualValues(t, 1, calls.Load())
+			assert.ElementsMatch(t, []string{
+				`_acme-challenge TXT 0 "other" 3600`,
+				`_acme-challenge TXT 0 "token" 3600`,
+			}, f.zone("example.com"))
+		})
+	}
+}
+
+func TestRetriedWriteNotApplied(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	var calls atomic.Int32
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "upstream timed out", http.StatusGatewayTimeout)
+			return
+		}
+		f.serveReplace(w, r.Form)
+	}
+	c := newTestClient(t, f, func(c *Client) {
+		c.MaxRetries = 0 // the default, 3
+		c.clock = newFakeClock()
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, calls.Load())
+	assert.Equal(t, []string{`_acme-challenge TXT 0 "token" 3600`}, f.zone("example.com"))
+}
+
+func TestOperationKey(t *testing.T) {
+	key := operationKey("example.com", "_acme-challenge", "TXT", []string{"a", "b"}, 300)
+
+	assert.Equal(t, key, operationKey("example.com", "_acme-challenge", "TXT", []string{"b", "a"}, 300))
+	assert.NotEqual(t, key, operationKey("example.com", "_acme-challenge", "TXT", []string{"a", "b"}, 600))
+	assert.NotEqual(t, key, operationKey("example.com", "_acme-challenge", "TXT", []string{"ab"}, 300))
+	assert.NotEqual(t, key, operationKey("example.org", "_acme-challenge", "TXT", []string{"a", "b"}, 300))
+}
+
+func TestWriteCheckSkipsRelogin(t *testing.T) {
+	tests := []struct {
+		name string
+		// failLogins is how many logins after the first answer 503
+		failLogins int32
+		wantErr    bool
+	}{
+		{name: "login recovers", failLogins: 1},
+		{name: "login keeps failing", failLogins: 100, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			var logins, puts, failing atomic.Int32
+			failing.Store(tt.failLogins)
+			f.dmapi = func(w http.ResponseWriter, cmd string, form url.Values) bool {
+				switch cmd {
+				case "login":
+					if n := logins.Add(1); n > 1 && n <= 1+failing.Load() {
+						http.Error(w, "unavailable", http.StatusServiceUnavailable)
+						return true
+					}
+				case "dns-zone-put":
+					if puts.Add(1) == 1 {
+						fmt.Fprint(w, "Status-Code: 2200\nStatus-Text: Authorization error\n\n")
+						return true
+					}
+				}
+				return false
+			}
+			c := newTestClient(t, f, func(c *Client) {
+				c.Mode = modeDMAPI
+				c.MaxRetries = 0 // the default, 3
+				c.clock = newFakeClock()
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			_, err := c.AppendRecords(ctx, "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			require.NoError(t, ctx.Err(), "write hung")
+			if tt.wantErr {
+				var apiErr *APIError
+				require.ErrorAs(t, err, &apiErr)
+				assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+
+				// Neither the session nor the zone is left locked
+				failing.Store(0)
+				_, err = c.AppendRecords(ctx, "example.com", []libdns.Record{
+					libdns.TXT{Name: "_acme-challenge", Text: "token"},
+				})
+				require.NoError(t, ctx.Err(), "later write hung")
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []string{`_acme-challenge TXT 0 "token" 3600`}, f.zone("example.com"))
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package joker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeJoker serves the parts of Joker's /nic/replace and DMAPI that the
+// provider uses, keeping each zone as the lines dns-zone-get lists.
+type fakeJoker struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	zones    map[string][]string
+	requests []fakeRequest
+
+	// replace, if set, answers /nic/replace requests instead of the
+	// zone logic
+	replace http.HandlerFunc
+	// dmapi, if set, answers the DMAPI commands it returns true for
+	dmapi func(w http.ResponseWriter, cmd string, form url.Values) bool
+}
+
+// fakeRequest is a request the fake received.
+type fakeRequest struct {
+	Path   string
+	Method string
+	Header http.Header
+	Form   url.Values
+}
+
+func newFakeJoker(t *testing.T) *fakeJoker {
+	t.Helper()
+
+	f := &fakeJoker{zones: make(map[string][]string)}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// setZone replaces zone's lines.
+func (f *fakeJoker) setZone(zone string, lines ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.zones[zone] = lines
+}
+
+// zone returns zone's lines.
+func (f *fakeJoker) zone(zone string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.zones[zone])
+}
+
+// received returns the requests made to path.
+func (f *fakeJoker) received(path string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var reqs []fakeRequest
+	for _, r := range f.requests {
+		if r.Path == path {
+			reqs = append(reqs, r)
+		}
+	}
+	return reqs
+}
+
+// forget drops the requests received so far.
+func (f *fakeJoker) forget() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = nil
+}
+
+func (f *fakeJoker) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	form, err := requestForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{
+		Path:   r.URL.Path,
+		Method: r.Method,
+		Header: r.Header.Clone(),
+		Form:   form,
+	})
+	f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == nicReplacePath:
+		if f.replace != nil {
+			f.replace(w, r)
+			return
+		}
+		f.serveReplace(w, form)
+	case strings.HasPrefix(r.URL.Path, dmapiPath+"/"):
+		cmd := strings.TrimPrefix(r.URL.Path, dmapiPath+"/")
+		if f.dmapi != nil && f.dmapi(w, cmd, form) {
+			return
+		}
+		f.serveDMAPI(w, cmd, form)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// requestForm returns the fields of a form, query or JSON request.
+func requestForm(r *http.Request) (url.Values, error) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		return r.Form, nil
+	}
+
+	var fields map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	for k, v := range fields {
+		form.Set(k, v)
+	}
+	return form, nil
+}
+
+// serveReplace replaces the label/type RRset with the comma separated
+// values, as /nic/replace does.
+func (f *fakeJoker) serveReplace(w http.ResponseWriter, form url.Values) {
+	zone, label, rtype := form.Get("zone"), form.Get("label"), form.Get("type")
+
+	var lines []string
+	if v := form.Get("value"); v != "" {
+		for _, value := range strings.Split(v, ",") {
+			if rtype == "TXT" && !strings.HasPrefix(value, `"`) {
+				value = quoteZoneString(value)
+			}
+			lines = append(lines, fmt.Sprintf("%s %s 0 %s %s", label, rtype, value, form.Get("ttl")))
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	zoneLines := slices.DeleteFunc(f.zones[zone], func(line string) bool {
+		return zoneLineMatches(line, label, rtype, zone)
+	})
+	f.zones[zone] = append(zoneLines, lines...)
+
+	fmt.Fprint(w, "OK")
+}
+
+func (f *fakeJoker) serveDMAPI(w http.ResponseWriter, cmd string, form url.Values) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if cmd != "login" && form.Get("auth-sid") != "sid-1" {
+		fmt.Fprint(w, "Status-Code: 2200\nStatus-Text: Authorization error\n\n")
+		return
+	}
+
+	switch cmd {
+	case "login":
+		fmt.Fprint(w, "Status-Code: 0\nStatus-Text: OK\nAuth-Sid: sid-1\n\n")
+	case "dns-zone-get":
+		lines, ok := f.zones[form.Get("domain")]
+		if !ok {
+			fmt.Fprint(w, "Status-Code: 2303\nStatus-Text: Object does not exist\n\n")
+			return
+		}
+		fmt.Fprintf(w, "Status-Code: 0\nStatus-Text: OK\n\n%s\n", strings.Join(lines, "\n"))
+	case "dns-zone-put":
+		var lines []string
+		for _, line := range strings.Split(form.Get("zone"), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		f.zones[form.Get("domain")] = lines
+		fmt.Fprint(w, "Status-Code: 0\nStatus-Text: OK\n\n")
+	case "query-domain-list":
+		var domains []string
+		for zone := range f.zones {
+			domains = append(domains, zone+" 2030-01-01")
+		}
+		slices.Sort(domains)
+		fmt.Fprintf(w, "Status-Code: 0\nStatus-Text: OK\n\n%s\n", strings.Join(domains, "\n"))
+	default:
+		fmt.Fprint(w, "Status-Code: 2000\nStatus-Text: Unknown command\n\n")
+	}
+}
+
+// newTestClient returns a Client set up to talk to f, after applying
+// configure. Retries are off unless configure turns them on.
+func newTestClient(t *testing.T, f *fakeJoker, configure ...func(*Client)) *Client {
+	t.Helper()
+
+	c := &Client{
+		APIToken:      "secret-token",
+		Endpoint:      f.srv.URL + nicReplacePath,
+		DMAPIEndpoint: f.srv.URL + dmapiPath,
+		MaxRetries:    -1,
+	}
+	for _, fn := range configure {
+		fn(c)
+	}
+	if err := c.setup(); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	return c
+}
+
+// fakeClock is a clock whose waits return at once, moving it forward by
+// the time waited.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// advance moves the clock forward by d.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// waited returns the durations waited for so far.
+func (c *fakeClock) waited() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.waits)
+}
+
+// noJitter makes retry delays deterministic.
+func noJitter(d time.Duration) time.Duration { return d }
@@ -0,0 +1,97 @@
+package joker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// jokerMetrics are shared by every Client; they are registered with each
+// registry passed to RegisterMetrics (Caddy's on Provision).
+var jokerMetrics = struct {
+	requests *prometheus.CounterVec
+	failures *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	rewrites *prometheus.CounterVec
+}{
+	requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "dns_joker",
+		Name:      "requests_total",
+		Help:      "Record updates sent to the Joker API.",
+	}, []string{"type"}),
+	failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "dns_joker",
+		Name:      "request_failures_total",
+		Help:      "Failed record updates sent to the Joker API, by category.",
+	}, []string{"type", "category"}),
+	duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "caddy",
+		Subsystem: "dns_joker",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of record updates sent to the Joker API.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type"}),
+	rewrites: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "dns_joker",
+		Name:      "value_rewrites_total",
+		Help:      "Record names, values and TTLs changed before sending, by kind.",
+	}, []string{"kind"}),
+}
+
+// RegisterMetrics adds the collectors to registry. Registering twice (more
+// than one Client sharing a registry) is not an error.
+func RegisterMetrics(registry *prometheus.Registry) error {
+	if registry == nil {
+		return nil
+	}
+	for _, c := range []prometheus.Collector{
+		jokerMetrics.requests,
+		jokerMetrics.failures,
+		jokerMetrics.duration,
+		jokerMetrics.rewrites,
+	} {
+		var are prometheus.AlreadyRegisteredError
+		if err := registry.Register(c); err != nil && !errors.As(err, &are) {
+			return err
+		}
+	}
+	return nil
+}
+
+// observeRequest records the outcome of one record update.
+func observeRequest(rtype string, start time.Time, err error) {
+	jokerMetrics.requests.WithLabelValues(rtype).Inc()
+	jokerMetrics.duration.WithLabelValues(rtype).Observe(time.Since(start).Seconds())
+	if err != nil {
+		jokerMetrics.failures.WithLabelValues(rtype, failureCategory(err)).Inc()
+	}
+}
+
+// failureCategory buckets an error for the failures counter.
+func failureCategory(err error) string {
+	var apiErr *APIError
+	switch {
+	case errors.Is(err, ErrAuth):
+		return "auth"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 500:
+		return "server_error"
+	case errors.As(err, &apiErr):
+		return "api_error"
+	default:
+		return "network"
+	}
+}
+
+// observeRewrite counts a change the Client made to a record before
+// sending it: "ttl_clamp", "txt_unquote", "txt_chunk" or "punycode".
+func observeRewrite(kind string) {
+	jokerMetrics.rewrites.WithLabelValues(kind).Inc()
+}
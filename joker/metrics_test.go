@@ -0,0 +1,123 @@
+package joker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/libdns/libdns"
+)
+
+func TestFailureCounter(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "badauth")
+	}
+	c := newTestClient(t, f)
+
+	failures := jokerMetrics.failures.WithLabelValues("TXT", "auth")
+	requests := jokerMetrics.requests.WithLabelValues("TXT")
+	failuresBefore, requestsBefore := testutil.ToFloat64(failures), testutil.ToFloat64(requests)
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.Error(t, err)
+
+	assert.Equal(t, failuresBefore+1, testutil.ToFloat64(failures))
+	assert.Equal(t, requestsBefore+1, testutil.ToFloat64(requests))
+}
+
+func TestFailureCategory(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: &APIError{StatusCode: http.StatusOK, Err: ErrAuth}, want: "auth"},
+		{err: newStatusError(http.StatusTooManyRequests, ""), want: "rate_limited"},
+		{err: fmt.Errorf("wrapped: %w", ErrCircuitOpen), want: "circuit_open"},
+		{err: newStatusError(http.StatusBadGateway, ""), want: "server_error"},
+		{err: newStatusError(http.StatusBadRequest, ""), want: "api_error"},
+		{err: context.DeadlineExceeded, want: "network"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, failureCategory(tt.err))
+		})
+	}
+}
+
+func TestRegisterMetricsTwice(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, RegisterMetrics(registry))
+	require.NoError(t, RegisterMetrics(registry))
+	require.NoError(t, RegisterMetrics(nil))
+}
+
+func TestRewritesLoggedAndCounted(t *testing.T) {
+	tests := []struct {
+		kind   string
+		zone   string
+		record libdns.Record
+		msg    string
+	}{
+		{
+			kind:   "ttl_clamp",
+			zone:   "example.com",
+			record: libdns.TXT{Name: "_acme-challenge", TTL: 10 * time.Second, Text: "token"},
+			msg:    "TTL out of range, clamping",
+		},
+		{
+			kind:   "txt_unquote",
+			zone:   "example.com",
+			record: libdns.RR{Name: "_acme-challenge", Type: "TXT", Data: `"token"`},
+			msg:    "normalized TXT value",
+		},
+		{
+			kind:   "txt_chunk",
+			zone:   "example.com",
+			record: libdns.TXT{Name: "selector._domainkey", Text: strings.Repeat("k", 300)},
+			msg:    "split long TXT value into strings",
+		},
+		{
+			kind:   "punycode",
+			zone:   "münchen.de",
+			record: libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			msg:    "converted name to punycode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			f.setZone("xn--mnchen-3ya.de")
+			core, logs := observer.New(zapcore.DebugLevel)
+			c := newTestClient(t, f, func(c *Client) {
+				c.logger = zap.New(core)
+			})
+
+			rewrites := jokerMetrics.rewrites.WithLabelValues(tt.kind)
+			before := testutil.ToFloat64(rewrites)
+
+			_, err := c.AppendRecords(context.Background(), tt.zone, []libdns.Record{tt.record})
+			require.NoError(t, err)
+
+			assert.Greater(t, testutil.ToFloat64(rewrites), before)
+			assert.NotZero(t, logs.FilterMessage(tt.msg).Len(), "no %q log entry", tt.msg)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package joker
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// Option configures a Client created with New or set up with Setup.
+type Option func(*Client)
+
+// WithHTTPClient makes the Client send requests through hc, e.g. for
+// mTLS or a tracing transport. The timeout and proxy settings are then
+// hc's responsibility.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.client = hc
+	}
+}
+
+// WithLogger sets the logger; by default a Client made with New logs
+// nothing.
+func WithLogger(l *zap.Logger) Option {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// New returns a Client authenticating with username and password, ready
+// for use. Set APIToken on the result (with empty username
+// and password) to authenticate with an API key instead.
+func New(username, password string, opts ...Option) *Client {
+	c := &Client{
+		Username: username,
+		Password: password,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	// Only a bad proxy URL can fail here, and New doesn't set one
+	_ = c.setup()
+	return c
+}
+
+// NewWithAPIToken returns a Client authenticating with a Joker API token,
+// ready for use.
+func NewWithAPIToken(token string, opts ...Option) *Client {
+	c := &Client{APIToken: token}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	_ = c.setup()
+	return c
+}
+
+// SetHTTPClient replaces the client used for requests. It is not safe to
+// call concurrently with requests in flight.
+func (c *Client) SetHTTPClient(hc *http.Client) {
+	c.client = hc
+	c.setupReplaceClient()
+}
+
+// defaultHTTPClient is used when no client has been set up.
+var defaultHTTPClient = &http.Client{Timeout: defaultTimeout}
+
+// httpClient returns the configured client, falling back lazily to a
+// default one.
+func (c *Client) httpClient() *http.Client {
+	if c.client == nil {
+		return defaultHTTPClient
+	}
+	return c.client
+}
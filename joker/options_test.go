@@ -0,0 +1,116 @@
+package joker
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libdns/libdns"
+)
+
+// recordingTransport records the URL of each request before sending it to
+// target instead of the host it names.
+type recordingTransport struct {
+	target *url.URL
+
+	mu   sync.Mutex
+	urls []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	rt.urls = append(rt.urls, req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	rt.mu.Unlock()
+
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	target, err := url.Parse(f.srv.URL)
+	require.NoError(t, err)
+	rt := &recordingTransport{target: target}
+
+	c := New("user", "pass", WithHTTPClient(&http.Client{Transport: rt}))
+
+	_, err = c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	assert.Contains(t, rt.urls, defaultEndpoint)
+}
+
+func TestSetHTTPClient(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	target, err := url.Parse(f.srv.URL)
+	require.NoError(t, err)
+	rt := &recordingTransport{target: target}
+
+	c := NewWithAPIToken("secret-token")
+	c.SetHTTPClient(&http.Client{Transport: rt})
+
+	_, err = c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	assert.Contains(t, rt.urls, defaultEndpoint)
+}
+
+func TestHTTPClientDefault(t *testing.T) {
+	var c Client
+	assert.Same(t, defaultHTTPClient, c.httpClient())
+}
+
+func TestClientWithoutCaddy(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+
+	client := NewWithAPIToken("secret-token", func(c *Client) {
+		c.Endpoint = f.srv.URL + nicReplacePath
+		c.DMAPIEndpoint = f.srv.URL + dmapiPath
+	})
+	ctx := context.Background()
+
+	_, err := client.AppendRecords(ctx, "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	rec, ok, err := client.GetRecord(ctx, "example.com", "_acme-challenge", "TXT")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, libdns.TXT{Name: "_acme-challenge", TTL: time.Hour, Text: "token"}, rec)
+
+	zones, err := client.ListZones(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []libdns.Zone{{Name: "example.com."}}, zones)
+
+	_, err = client.DeleteRecords(ctx, "example.com", []libdns.Record{rec})
+	require.NoError(t, err)
+	assert.Empty(t, f.zone("example.com"))
+}
+
+func TestClientWithoutLogger(t *testing.T) {
+	c := &Client{}
+	assert.NotPanics(t, func() {
+		c.clampTTL(30, "example.com", "www", "A")
+		c.log(withRequestID(context.Background())).Info("logged nowhere")
+	})
+}
@@ -0,0 +1,182 @@
+package joker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPropagationTimeout = 2 * time.Minute
+	propagationInterval       = 5 * time.Second
+)
+
+// propagationTypes are the record types whose propagation can be checked
+// with the standard library resolver. Other types are not waited for.
+var propagationTypes = map[string]bool{
+	"A":    true,
+	"AAAA": true,
+	"TXT":  true,
+}
+
+func (c *Client) propagationTimeout() time.Duration {
+	if c.PropagationTimeout > 0 {
+		return time.Duration(c.PropagationTimeout)
+	}
+	return defaultPropagationTimeout
+}
+
+// waitForPropagation polls the zone's authoritative nameservers until all
+// values are visible for the RRset, or PropagationTimeout elapses.
+func (c *Client) waitForPropagation(ctx context.Context, key rrsetKey, values []string) error {
+	if !c.WaitForPropagation || c.DryRun {
+		return nil
+	}
+	if !propagationTypes[key.rtype] {
+		c.log(ctx).Debug("not waiting for propagation of unsupported type",
+			zap.String("type", key.rtype),
+		)
+		return nil
+	}
+
+	zone, err := toASCII(normalizeZone(key.zone))
+	if err != nil {
+		return err
+	}
+	label, err := toASCII(key.label)
+	if err != nil {
+		return err
+	}
+	name := joinName(label, zone)
+
+	timeout := c.propagationTimeout()
+	wctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	r := c.propagationResolver(wctx, zone)
+
+	for {
+		found, err := lookupValues(wctx, r, key.rtype, name)
+		if err == nil && containsValues(key.rtype, found, values) {
+			c.log(ctx).Debug("DNS record propagated",
+				zap.String("name", name),
+				zap.String("type", key.rtype),
+			)
+			return nil
+		}
+
+		c.log(ctx).Debug("waiting for DNS record to propagate",
+			zap.String("name", name),
+			zap.String("type", key.rtype),
+			zap.Strings("found", found),
+			zap.Error(err),
+		)
+
+		select {
+		case <-wctx.Done():
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w: %s %s not visible after %s",
+				ErrPropagationTimeout, name, key.rtype, timeout)
+		case <-c.clk().After(propagationInterval):
+		}
+	}
+}
+
+// propagationResolver returns a resolver for propagation checks. The
+// configured Resolvers take precedence; otherwise the zone's
+// authoritative nameservers are queried directly, rotating through them
+// on each query. If they can't be found the system resolver is used.
+func (c *Client) propagationResolver(ctx context.Context, zone string) *net.Resolver {
+	if len(c.Resolvers) > 0 {
+		return newServerResolver(resolverAddrs(c.Resolvers))
+	}
+
+	nss, err := net.DefaultResolver.LookupNS(ctx, zone)
+	if err != nil || len(nss) == 0 {
+		c.log(ctx).Debug("using system resolver for propagation checks",
+			zap.String("zone", zone),
+			zap.Error(err),
+		)
+		return net.DefaultResolver
+	}
+
+	servers := make([]string, 0, len(nss))
+	for _, ns := range nss {
+		servers = append(servers, net.JoinHostPort(ns.Host, "53"))
+	}
+	return newServerResolver(servers)
+}
+
+// newServerResolver returns a resolver that sends every query to one of
+// servers (host:port), in turn.
+func newServerResolver(servers []string) *net.Resolver {
+	var next atomic.Uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := servers[int(next.Add(1)-1)%len(servers)]
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// resolverAddrs adds the default DNS port to addresses without one.
+func resolverAddrs(resolvers []string) []string {
+	addrs := make([]string, 0, len(resolvers))
+	for _, r := range resolvers {
+		if _, _, err := net.SplitHostPort(r); err != nil {
+			r = net.JoinHostPort(strings.Trim(r, "[]"), "53")
+		}
+		addrs = append(addrs, r)
+	}
+	return addrs
+}
+
+func lookupValues(ctx context.Context, r *net.Resolver, rtype, name string) ([]string, error) {
+	switch rtype {
+	case "TXT":
+		return r.LookupTXT(ctx, name)
+	case "A", "AAAA":
+		network := "ip4"
+		if rtype == "AAAA" {
+			network = "ip6"
+		}
+		addrs, err := r.LookupNetIP(ctx, network, name)
+		if err != nil {
+			return nil, err
+		}
+		found := make([]string, 0, len(addrs))
+		for _, a := range addrs {
+			found = append(found, a.Unmap().String())
+		}
+		return found, nil
+	}
+	return nil, errors.New("unsupported record type " + rtype)
+}
+
+// containsValues reports whether every expected value is among found.
+// Addresses are compared in canonical form.
+func containsValues(rtype string, found, expected []string) bool {
+	for _, v := range expected {
+		if rtype == "A" || rtype == "AAAA" {
+			if addr, err := netip.ParseAddr(v); err == nil {
+				v = addr.Unmap().String()
+			}
+		}
+		if !slices.Contains(found, v) {
+			return false
+		}
+	}
+	return true
+}
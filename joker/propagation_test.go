@@ -0,0 +1,221 @@
+package joker
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/libdns/libdns"
+)
+
+// fakeDNS is a UDP nameserver answering TXT queries from its records.
+type fakeDNS struct {
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	txt     map[string][]string
+	queries map[string]int
+	hideFor int
+}
+
+func newFakeDNS(t *testing.T) *fakeDNS {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	d := &fakeDNS{
+		conn:    conn,
+		txt:     make(map[string][]string),
+		queries: make(map[string]int),
+	}
+	go d.serve()
+	return d
+}
+
+func (d *fakeDNS) addr() string {
+	return d.conn.LocalAddr().String()
+}
+
+// setTXT sets the TXT values of name, a fully qualified name.
+func (d *fakeDNS) setTXT(name string, values ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.txt[name] = values
+}
+
+// hide makes the first n queries for each name get no answer, as if its
+// records hadn't propagated yet.
+func (d *fakeDNS) hide(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hideFor = n
+}
+
+// queried returns how many queries name has received.
+func (d *fakeDNS) queried(name string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queries[name]
+}
+
+func (d *fakeDNS) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if msg, err := d.answer(buf[:n]); err == nil {
+			d.conn.WriteTo(msg, addr)
+		}
+	}
+}
+
+func (d *fakeDNS) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	h, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	name := q.Name.String()
+	d.mu.Lock()
+	d.queries[name]++
+	var values []string
+	if q.Type == dnsmessage.TypeTXT && d.queries[name] > d.hideFor {
+		values = d.txt[name]
+	}
+	d.mu.Unlock()
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:            h.ID,
+		Response:      true,
+		Authoritative: true,
+	})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		err := b.TXTResource(dnsmessage.ResourceHeader{
+			Name:  q.Name,
+			Class: dnsmessage.ClassINET,
+			TTL:   60,
+		}, dnsmessage.TXTResource{TXT: []string{v}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func TestWaitForPropagation(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	dns := newFakeDNS(t)
+	dns.setTXT("_acme-challenge.example.com.", "token")
+	dns.hide(2)
+	clk := newFakeClock()
+	c := newTestClient(t, f, func(c *Client) {
+		c.WaitForPropagation = true
+		c.Resolvers = []string{dns.addr()}
+		c.clock = clk
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, dns.queried("_acme-challenge.example.com."))
+	assert.Equal(t, []time.Duration{propagationInterval, propagationInterval}, clk.waited())
+}
+
+func TestWaitForPropagationTimeout(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	dns := newFakeDNS(t)
+	c := newTestClient(t, f, func(c *Client) {
+		c.WaitForPropagation = true
+		c.PropagationTimeout = Duration(100 * time.Millisecond)
+		c.Resolvers = []string{dns.addr()}
+		c.clock = newFakeClock()
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	assert.ErrorIs(t, err, ErrPropagationTimeout)
+}
+
+func TestWaitForPropagationHonorsContext(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	dns := newFakeDNS(t)
+	c := newTestClient(t, f, func(c *Client) {
+		c.WaitForPropagation = true
+		c.Resolvers = []string{dns.addr()}
+		c.clock = newFakeClock()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := c.AppendRecords(ctx, "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, ErrPropagationTimeout)
+}
+
+func TestResolvers(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	stale, fresh := newFakeDNS(t), newFakeDNS(t)
+	fresh.setTXT("_acme-challenge.example.com.", "token")
+	c := newTestClient(t, f, func(c *Client) {
+		c.WaitForPropagation = true
+		c.Resolvers = []string{stale.addr(), fresh.addr()}
+		c.clock = newFakeClock()
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, stale.queried("_acme-challenge.example.com."))
+	assert.Equal(t, 1, fresh.queried("_acme-challenge.example.com."))
+}
+
+func TestResolverAddrs(t *testing.T) {
+	assert.Equal(t, []string{
+		"1.1.1.1:53",
+		"9.9.9.9:5353",
+		"[2606:4700:4700::1111]:53",
+		"[2606:4700:4700::1001]:53",
+		"ns.example.net:53",
+	}, resolverAddrs([]string{
+		"1.1.1.1",
+		"9.9.9.9:5353",
+		"2606:4700:4700::1111",
+		"[2606:4700:4700::1001]",
+		"ns.example.net",
+	}))
+}
@@ -0,0 +1,157 @@
+package joker
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/libdns/libdns"
+)
+
+// ChangeKind is what Reconcile did to an RRset.
+type ChangeKind string
+
+const (
+	ChangeCreate ChangeKind = "create"
+	ChangeUpdate ChangeKind = "update"
+	ChangeDelete ChangeKind = "delete"
+)
+
+// Change is one RRset written by Reconcile. Before holds the records the
+// zone had (none for a create), After the records written (none for a
+// delete).
+type Change struct {
+	Kind   ChangeKind
+	Name   string
+	Type   string
+	Before []libdns.Record
+	After  []libdns.Record
+}
+
+// Reconcile makes zone hold exactly the desired records: RRsets missing
+// from the zone are created, those that differ in values or TTL are
+// replaced, and those not in desired are deleted, except the apex NS
+// records, which Joker manages. RRsets that already match are left alone,
+// so running it again with the same records changes nothing. It returns
+// the changes made, in name and type order. With DryRun the changes are
+// only logged, making the result a plan.
+func (c *Client) Reconcile(
+	ctx context.Context,
+	zone string,
+	desired []libdns.Record,
+) ([]Change, error) {
+	ctx = withRequestID(ctx)
+
+	if normalizeZone(zone) == "" {
+		return nil, errors.New("zone is required")
+	}
+	if err := checkTypes(desired); err != nil {
+		return nil, err
+	}
+	if err := checkNames(zone, desired); err != nil {
+		return nil, err
+	}
+	if err := checkAddresses(desired); err != nil {
+		return nil, err
+	}
+
+	current, err := c.getRecords(ctx, zone, false)
+	if err != nil {
+		return nil, err
+	}
+
+	want := reconcileGroups(zone, desired)
+	have := reconcileGroups(zone, current)
+
+	keys := slices.Collect(maps.Keys(want))
+	for key := range have {
+		if _, ok := want[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b rrsetKey) int {
+		return cmp.Or(cmp.Compare(a.label, b.label), cmp.Compare(a.rtype, b.rtype))
+	})
+
+	var (
+		changes []Change
+		errs    []error
+	)
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return changes, err
+		}
+
+		recs, wanted := want[key]
+		change := Change{Name: key.label, Type: key.rtype, Before: have[key]}
+
+		var (
+			values []string
+			ttl    int
+		)
+		switch {
+		case !wanted:
+			if key.label == "@" && key.rtype == "NS" {
+				continue
+			}
+			change.Kind = ChangeDelete
+			ttl = int(c.defaultTTL().Seconds())
+
+		default:
+			values = c.rrsetValues(key.rtype, recs)
+			ttl = c.clampTTL(c.recordTTL(recs), key.zone, key.label, key.rtype)
+			if c.rrsetMatches(current, key, values, ttl) {
+				continue
+			}
+			change.Kind = ChangeUpdate
+			if len(change.Before) == 0 {
+				change.Kind = ChangeCreate
+			}
+			change.After = c.storedRecords(key, recs, ttl)
+		}
+
+		c.log(ctx).Debug("reconciling DNS record",
+			zap.String("zone", key.zone),
+			zap.String("label", key.label),
+			zap.String("type", key.rtype),
+			zap.String("change", string(change.Kind)),
+		)
+
+		unlock := c.lockRRSet(key.zone, key.label, key.rtype)
+		rctx, cancel := c.rrsetContext(ctx)
+		err := ignoreNoChange(c.replaceRRSet(rctx, key.zone, key.label, key.rtype, values, ttl))
+		cancel()
+		unlock()
+		if err != nil {
+			if c.ContinueOnError {
+				errs = append(errs, rrsetError(key, err))
+				continue
+			}
+			return changes, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, errors.Join(errs...)
+}
+
+// reconcileGroups groups records into RRsets like groupRRSets, with labels
+// in the ASCII lower case form Joker stores, so desired and current
+// records of one RRset meet under the same key however they were written.
+func reconcileGroups(zone string, records []libdns.Record) map[rrsetKey][]libdns.Record {
+	grouped := make(map[rrsetKey][]libdns.Record)
+	for key, recs := range groupRRSets(zone, records) {
+		if label, err := toASCII(key.label); err == nil {
+			key.label = label
+		}
+		key.label = strings.ToLower(key.label)
+		key.rtype = strings.ToUpper(key.rtype)
+		grouped[key] = append(grouped[key], recs...)
+	}
+	return grouped
+}
@@ -0,0 +1,83 @@
+package joker
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libdns/libdns"
+)
+
+func TestReconcile(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com",
+		"@ NS 0 a.ns.joker.com 86400",
+		"www A 0 192.0.2.1 300",
+		"old A 0 192.0.2.9 300",
+		`_acme-challenge TXT 0 "keep" 300`,
+	)
+	c := newTestClient(t, f)
+	desired := []libdns.Record{
+		libdns.Address{Name: "WWW", TTL: 5 * time.Minute, IP: netip.MustParseAddr("192.0.2.2")},
+		libdns.TXT{Name: "_acme-challenge", TTL: 5 * time.Minute, Text: "keep"},
+		libdns.Address{Name: "new", TTL: 5 * time.Minute, IP: netip.MustParseAddr("2001:db8::1")},
+		libdns.CNAME{Name: "mail", TTL: 5 * time.Minute, Target: "mx.example.net."},
+	}
+
+	changes, err := c.Reconcile(context.Background(), "example.com", desired)
+	require.NoError(t, err)
+
+	type change struct {
+		kind ChangeKind
+		name string
+		typ  string
+	}
+	var got []change
+	for _, ch := range changes {
+		got = append(got, change{ch.Kind, ch.Name, ch.Type})
+	}
+	assert.Equal(t, []change{
+		{ChangeCreate, "mail", "CNAME"},
+		{ChangeCreate, "new", "AAAA"},
+		{ChangeDelete, "old", "A"},
+		{ChangeUpdate, "www", "A"},
+	}, got)
+	assert.Len(t, f.received(nicReplacePath), 4)
+
+	// The apex NS records are Joker's and are left alone
+	assert.Contains(t, f.zone("example.com"), "@ NS 0 a.ns.joker.com 86400")
+
+	// Running it again changes nothing
+	f.forget()
+	changes, err = c.Reconcile(context.Background(), "example.com", desired)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Empty(t, f.received(nicReplacePath))
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com", "old A 0 192.0.2.9 300")
+	c := newTestClient(t, f, func(c *Client) {
+		c.DryRun = true
+	})
+
+	changes, err := c.Reconcile(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", TTL: 5 * time.Minute, Text: "token"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, changes, 2)
+	assert.Empty(t, f.received(nicReplacePath))
+	assert.Equal(t, []string{"old A 0 192.0.2.9 300"}, f.zone("example.com"))
+}
+
+func TestReconcileRequiresZone(t *testing.T) {
+	c := newTestClient(t, newFakeJoker(t))
+
+	_, err := c.Reconcile(context.Background(), "", nil)
+	assert.ErrorContains(t, err, "zone is required")
+}
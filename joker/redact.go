@@ -0,0 +1,74 @@
+package joker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// redactedValue replaces secrets in logs and errors.
+const redactedValue = "***"
+
+// redact replaces every occurrence of the configured secrets, including
+// those of ZoneCredentials, raw or urlencoded, in s.
+func (c *Client) redact(s string) string {
+	secrets := []string{c.Password, c.APIToken, c.APIKey}
+	for _, cred := range c.ZoneCredentials {
+		secrets = append(secrets, cred.Password, cred.APIToken)
+	}
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		s = strings.ReplaceAll(s, secret, redactedValue)
+		if enc := url.QueryEscape(secret); enc != secret {
+			s = strings.ReplaceAll(s, enc, redactedValue)
+		}
+	}
+	return s
+}
+
+// String describes the Client without its secrets, so %v and %s are
+// safe to log. JSON marshalling is deliberately left alone: Caddy needs
+// the real values when adapting a Caddyfile.
+func (c Client) String() string {
+	return fmt.Sprintf(
+		"Client{Username:%q Password:%q APIToken:%q Endpoint:%q DMAPIEndpoint:%q}",
+		c.Username,
+		redactSecret(c.Password),
+		redactSecret(c.APIToken),
+		c.Endpoint,
+		c.DMAPIEndpoint,
+	)
+}
+
+// GoString makes %#v as safe as %v.
+func (c Client) GoString() string {
+	return c.String()
+}
+
+// MarshalLogObject lets the Client be logged with zap.Object without
+// exposing secrets.
+func (c Client) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("username", c.Username)
+	enc.AddString("password", redactSecret(c.Password))
+	enc.AddString("api_token", redactSecret(c.APIToken))
+	enc.AddString("endpoint", c.Endpoint)
+	if len(c.Endpoints) > 0 {
+		enc.AddString("endpoints", strings.Join(c.Endpoints, ","))
+	}
+	enc.AddString("dmapi_endpoint", c.DMAPIEndpoint)
+	return nil
+}
+
+// redactSecret masks a non-empty secret, keeping "" visible so a missing
+// credential is still obvious.
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redactedValue
+}
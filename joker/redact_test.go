@@ -0,0 +1,66 @@
+package joker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/libdns/libdns"
+)
+
+const testPassword = "hunter2&co"
+
+func TestErrorsRedactPassword(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		// Echo the request, as an error page might
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "rejected: %s (password %s)", r.Form.Encode(), r.Form.Get("password"))
+	}
+	core, logs := observer.New(zapcore.DebugLevel)
+	c := newTestClient(t, f, func(c *Client) {
+		c.APIToken = ""
+		c.Username = "alice"
+		c.Password = testPassword
+		c.logger = zap.New(core)
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "hunter2")
+	assert.Contains(t, err.Error(), redactedValue)
+
+	for _, entry := range logs.All() {
+		assert.NotContains(t, fmt.Sprint(entry.Message, entry.ContextMap()), "hunter2")
+	}
+}
+
+func TestClientStringRedactsSecrets(t *testing.T) {
+	c := Client{Username: "alice", Password: testPassword, APIToken: "secret-token"}
+
+	for _, s := range []string{
+		c.String(),
+		fmt.Sprintf("%v", c),
+		fmt.Sprintf("%+v", &c),
+		fmt.Sprintf("%#v", c),
+	} {
+		assert.NotContains(t, s, "hunter2")
+		assert.NotContains(t, s, "secret-token")
+		assert.Contains(t, s, "alice")
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	require.NoError(t, c.MarshalLogObject(enc))
+	assert.Equal(t, redactedValue, enc.Fields["password"])
+	assert.Equal(t, redactedValue, enc.Fields["api_token"])
+}
@@ -0,0 +1,46 @@
+package joker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"go.uber.org/zap"
+)
+
+type requestIDKey struct{}
+
+// withRequestID returns ctx carrying a new ID for one Client operation,
+// unless ctx already has one, so the log entries (and optionally the
+// requests) of e.g. a whole certificate renewal can be correlated.
+func withRequestID(ctx context.Context) context.Context {
+	if requestID(ctx) != "" {
+		return ctx
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return context.WithValue(ctx, requestIDKey{}, hex.EncodeToString(b))
+}
+
+// requestID returns the operation ID carried by ctx, if any.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// log returns the Client's logger, tagged with ctx's request ID.
+func (c *Client) log(ctx context.Context) *zap.Logger {
+	if id := requestID(ctx); id != "" {
+		return c.baseLogger().With(zap.String("request_id", id))
+	}
+	return c.baseLogger()
+}
+
+// baseLogger returns the Client's logger, or a no-op one for a Client
+// used without Setup or New, which set it.
+func (c *Client) baseLogger() *zap.Logger {
+	if c.logger == nil {
+		return zap.NewNop()
+	}
+	return c.logger
+}
@@ -0,0 +1,66 @@
+package joker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/libdns/libdns"
+)
+
+func TestRequestID(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	core, logs := observer.New(zap.DebugLevel)
+	c := newTestClient(t, f, func(c *Client) {
+		c.SendRequestID = true
+		c.logger = zap.New(core)
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+		libdns.TXT{Name: "_acme-challenge.www", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	reqs := f.received(nicReplacePath)
+	require.Len(t, reqs, 2)
+	id := reqs[0].Header.Get("X-Request-ID")
+	assert.Len(t, id, 16)
+	assert.Equal(t, id, reqs[1].Header.Get("X-Request-ID"))
+
+	added := logs.FilterMessage("adding DNS record").All()
+	require.Len(t, added, 2)
+	for _, e := range added {
+		assert.Equal(t, id, e.ContextMap()["request_id"])
+	}
+
+	// Each call is an operation of its own
+	f.forget()
+	_, err = c.DeleteRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+	reqs = f.received(nicReplacePath)
+	require.Len(t, reqs, 1)
+	assert.NotEmpty(t, reqs[0].Header.Get("X-Request-ID"))
+	assert.NotEqual(t, id, reqs[0].Header.Get("X-Request-ID"))
+}
+
+func TestRequestIDHeaderOff(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f)
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+	for _, r := range f.received(nicReplacePath) {
+		assert.Empty(t, r.Header.Get("X-Request-ID"))
+	}
+}
@@ -0,0 +1,150 @@
+package joker
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaxRetries = 3
+	retryBaseDelay    = time.Second
+	maxRetryAfter     = 60 * time.Second
+)
+
+// maxRetries returns the configured retry count, applying the default.
+func (c *Client) maxRetries() int {
+	switch {
+	case c.MaxRetries < 0:
+		return 0
+	case c.MaxRetries == 0:
+		return defaultMaxRetries
+	default:
+		return c.MaxRetries
+	}
+}
+
+// newJitter returns a full-jitter function drawing from its own seeded
+// source, so Clients renewing at the same moment spread their retries
+// out instead of hitting Joker in lockstep.
+func newJitter(seed uint64) func(time.Duration) time.Duration {
+	var mu sync.Mutex
+	rng := rand.New(rand.NewPCG(seed, seed>>32|seed<<32))
+
+	return func(d time.Duration) time.Duration {
+		if d <= 0 {
+			return d
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return time.Duration(rng.Int64N(int64(d) + 1))
+	}
+}
+
+// withRetry runs do until it succeeds, fails permanently or the retry
+// budget is spent, doubling the delay between attempts, with full jitter
+// if p.jitter is set. A 429 response waits for its Retry-After instead,
+// capped at maxRetryAfter. Waiting is abandoned as soon as ctx is done. A
+// write carrying a writeCheck is not repeated if the check finds the
+// earlier attempt took effect.
+func (c *Client) withRetry(
+	ctx context.Context,
+	do func() (*httpResult, error),
+) (*httpResult, error) {
+	backoff := retryBaseDelay
+	retries := c.maxRetries()
+
+	for attempt := 0; ; attempt++ {
+		res, err := do()
+		if !retryable(res, err) {
+			return res, err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return res, ctxErr
+		}
+		if attempt >= retries {
+			return res, err
+		}
+		if check := writeCheckFrom(ctx); check != nil && ambiguousFailure(res, err) && check.applied(ctx) {
+			c.log(ctx).Info("joker write already applied, not retrying",
+				zap.String("operation", check.key),
+				zap.Int("attempt", attempt+1),
+			)
+			return nil, errAlreadyApplied
+		}
+
+		delay := backoff
+		if c.jitter != nil {
+			delay = c.jitter(backoff)
+		}
+		status := 0
+		if res != nil {
+			status = res.status
+			if res.status == http.StatusTooManyRequests {
+				if d, ok := parseRetryAfter(res.header.Get("Retry-After"), c.clk().Now()); ok {
+					delay = min(d, maxRetryAfter)
+				}
+			}
+		}
+
+		c.log(ctx).Warn("joker request failed, retrying",
+			zap.Int("attempt", attempt+1),
+			zap.Int("status", status),
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return res, ctx.Err()
+		case <-c.clk().After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether a failed attempt is worth repeating: network
+// errors, 429 and 5xx responses are, other 4xx, successful replies and an
+// open circuit breaker are not.
+func retryable(res *httpResult, err error) bool {
+	if errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	if err != nil || res == nil {
+		return true
+	}
+	return res.status == http.StatusTooManyRequests ||
+		res.status >= http.StatusInternalServerError
+}
+
+// parseRetryAfter parses a Retry-After header in either delay-seconds or
+// HTTP-date form, returning the wait relative to now.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
@@ -0,0 +1,243 @@
+package joker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/libdns/libdns"
+)
+
+func TestRetryFlakyServer(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	var calls atomic.Int32
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "OK")
+	}
+	clk := newFakeClock()
+	c := newTestClient(t, f, func(c *Client) {
+		c.MaxRetries = 0 // the default, 3
+		c.clock = clk
+		c.jitter = noJitter
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, calls.Load())
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, clk.waited())
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	var calls atomic.Int32
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "down", http.StatusBadGateway)
+	}
+	c := newTestClient(t, f, func(c *Client) {
+		c.MaxRetries = 2
+		c.clock = newFakeClock()
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+	assert.EqualValues(t, 3, calls.Load())
+}
+
+func TestNoRetryOnPermanentFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{name: "4xx", status: http.StatusBadRequest, body: "bad request"},
+		{name: "badauth", status: http.StatusOK, body: "badauth"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			var calls atomic.Int32
+			f.replace = func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}
+			c := newTestClient(t, f, func(c *Client) {
+				c.MaxRetries = 3
+				c.clock = newFakeClock()
+			})
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			require.Error(t, err)
+			assert.EqualValues(t, 1, calls.Load())
+		})
+	}
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		cancel()
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}
+	c := newTestClient(t, f, func(c *Client) {
+		c.MaxRetries = 3
+	})
+
+	_, err := c.AppendRecords(ctx, "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.EqualValues(t, 1, calls.Load())
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter func(now time.Time) string
+		want       time.Duration
+	}{
+		{
+			name:       "seconds",
+			retryAfter: func(time.Time) string { return "2" },
+			want:       2 * time.Second,
+		},
+		{
+			name: "HTTP date",
+			retryAfter: func(now time.Time) string {
+				return now.Add(5 * time.Second).Format(http.TimeFormat)
+			},
+			want: 5 * time.Second,
+		},
+		{
+			name:       "capped",
+			retryAfter: func(time.Time) string { return "3600" },
+			want:       maxRetryAfter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+			clk := newFakeClock()
+			var calls atomic.Int32
+			f.replace = func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					w.Header().Set("Retry-After", tt.retryAfter(clk.Now()))
+					http.Error(w, "slow down", http.StatusTooManyRequests)
+					return
+				}
+				fmt.Fprint(w, "OK")
+			}
+			c := newTestClient(t, f, func(c *Client) {
+				c.MaxRetries = 1
+				c.clock = clk
+			})
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			require.NoError(t, err)
+			assert.EqualValues(t, 2, calls.Load())
+			assert.Equal(t, []time.Duration{tt.want}, clk.waited())
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "2", want: 2 * time.Second, wantOK: true},
+		{value: " 0 ", want: 0, wantOK: true},
+		{value: now.Add(time.Minute).Format(http.TimeFormat), want: time.Minute, wantOK: true},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0, wantOK: true},
+		{value: ""},
+		{value: "-1"},
+		{value: "soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	delays := func(seed uint64) []time.Duration {
+		f := newFakeJoker(t)
+		f.setZone("example.com")
+		f.replace = func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		}
+		clk := newFakeClock()
+		c := newTestClient(t, f, func(c *Client) {
+			c.MaxRetries = 4
+			c.clock = clk
+			c.jitter = newJitter(seed)
+		})
+
+		_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+			libdns.TXT{Name: "_acme-challenge", Text: "token"},
+		})
+		require.Error(t, err)
+		return clk.waited()
+	}
+
+	first := delays(42)
+	require.Len(t, first, 4)
+	backoff := retryBaseDelay
+	for _, d := range first {
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.LessOrEqual(t, d, backoff)
+		backoff *= 2
+	}
+
+	// The same seed repeats the delays, another spreads them differently
+	assert.Equal(t, first, delays(42))
+	assert.NotEqual(t, first, delays(7))
+}
+
+func TestNewJitter(t *testing.T) {
+	jitter := newJitter(1)
+	assert.Equal(t, time.Duration(0), jitter(0))
+	for range 100 {
+		d := jitter(time.Second)
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.LessOrEqual(t, d, time.Second)
+	}
+}
@@ -0,0 +1,89 @@
+package joker
+
+import (
+	"context"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// ZoneSnapshot is a backup of a zone's records, serializable as JSON.
+type ZoneSnapshot struct {
+	Zone    string           `json:"zone"`
+	Records []SnapshotRecord `json:"records"`
+}
+
+// SnapshotRecord is one record of a ZoneSnapshot. Name is relative to the
+// zone and TTL is in seconds.
+type SnapshotRecord struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  int    `json:"ttl"`
+	Data string `json:"data"`
+}
+
+// ExportZone returns a snapshot of every record in zone.
+func (c *Client) ExportZone(ctx context.Context, zone string) (*ZoneSnapshot, error) {
+	records, err := c.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &ZoneSnapshot{Zone: normalizeZone(zone)}
+	for _, rec := range records {
+		rr := rec.RR()
+		snap.Records = append(snap.Records, SnapshotRecord{
+			Name: rr.Name,
+			Type: rr.Type,
+			TTL:  int(rr.TTL.Seconds()),
+			Data: rr.Data,
+		})
+	}
+	return snap, nil
+}
+
+// ImportZone writes the records of snap into zone with SetRecords
+// semantics: each RRset in the snapshot replaces the one in the zone.
+// With replaceAll, RRsets in the zone that the snapshot lacks are deleted
+// too, except the apex NS records, which Joker manages.
+func (c *Client) ImportZone(ctx context.Context, zone string, snap *ZoneSnapshot, replaceAll bool) error {
+	ctx = withRequestID(ctx)
+
+	records := make([]libdns.Record, 0, len(snap.Records))
+	for _, r := range snap.Records {
+		records = append(records, libdns.RR{
+			Name: r.Name,
+			Type: r.Type,
+			TTL:  time.Duration(r.TTL) * time.Second,
+			Data: r.Data,
+		})
+	}
+
+	var stale []libdns.Record
+	if replaceAll {
+		current, err := c.getRecords(ctx, zone, false)
+		if err != nil {
+			return err
+		}
+
+		keep := groupRRSets(zone, records)
+		for key, recs := range groupRRSets(zone, current) {
+			if _, ok := keep[key]; ok || (key.label == "@" && key.rtype == "NS") {
+				continue
+			}
+			stale = append(stale, recs...)
+		}
+	}
+
+	if len(records) > 0 {
+		if _, err := c.SetRecords(ctx, zone, records); ignoreNoChange(err) != nil {
+			return err
+		}
+	}
+	if len(stale) > 0 {
+		if _, err := c.DeleteRecords(ctx, zone, stale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
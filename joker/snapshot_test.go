@@ -0,0 +1,73 @@
+package joker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	source := []string{
+		"@ A 0 192.0.2.1 3600",
+		"@ MX 10 mail.example.com 3600",
+		`_acme-challenge TXT 0 "token" 300`,
+		"www CNAME 0 example.com 3600",
+	}
+
+	tests := []struct {
+		name       string
+		replaceAll bool
+		keepsOld   bool
+		wantLen    int
+	}{
+		{name: "merge", keepsOld: true, wantLen: len(source) + 2},
+		{name: "replace all", replaceAll: true, wantLen: len(source) + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com", source...)
+			f.setZone("example.org", "@ NS 0 a.ns.joker.com 86400", "old A 0 192.0.2.9 3600")
+			c := newTestClient(t, f, func(c *Client) {
+				c.Mode = modeDMAPI
+			})
+			ctx := context.Background()
+
+			snap, err := c.ExportZone(ctx, "example.com.")
+			require.NoError(t, err)
+			assert.Equal(t, "example.com", snap.Zone)
+			assert.Len(t, snap.Records, len(source))
+
+			data, err := json.Marshal(snap)
+			require.NoError(t, err)
+			var restored ZoneSnapshot
+			require.NoError(t, json.Unmarshal(data, &restored))
+			assert.Equal(t, *snap, restored)
+
+			require.NoError(t, c.ImportZone(ctx, "example.org", &restored, tt.replaceAll))
+
+			want, err := c.GetRecords(ctx, "example.com")
+			require.NoError(t, err)
+			got, err := c.GetRecords(ctx, "example.org")
+			require.NoError(t, err)
+			for _, rec := range want {
+				assert.Contains(t, got, rec)
+			}
+
+			// The apex NS records Joker manages always survive
+			var ns, old bool
+			for _, rec := range got {
+				rr := rec.RR()
+				ns = ns || rr.Name == "@" && rr.Type == "NS"
+				old = old || rr.Name == "old"
+			}
+			assert.True(t, ns)
+			assert.Equal(t, tt.keepsOld, old)
+			assert.Len(t, got, tt.wantLen)
+		})
+	}
+}
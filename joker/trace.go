@@ -0,0 +1,97 @@
+package joker
+
+import (
+	"net/url"
+	"regexp"
+
+	"go.uber.org/zap"
+)
+
+// Trace holds optional callbacks observing every HTTP exchange with Joker,
+// for debugging. Credentials in forms and bodies are redacted before the
+// callbacks see them. Either callback may be nil.
+type Trace struct {
+	OnRequest  func(method, endpoint string, form url.Values)
+	OnResponse func(method, endpoint string, status int, body string)
+}
+
+// WithTrace installs trace callbacks on a Client created with New.
+func WithTrace(t Trace) Option {
+	return func(c *Client) {
+		c.trace = &t
+	}
+}
+
+// redactForm returns a copy of form with its secrets replaced, including
+// secret fields renamed by fieldMap.
+func redactForm(form url.Values, fieldMap map[string]string) url.Values {
+	secret := map[string]bool{
+		"password":  true,
+		"api_token": true,
+		"api-key":   true,
+		"auth-sid":  true,
+	}
+	for from, to := range fieldMap {
+		if secret[from] {
+			secret[to] = true
+		}
+	}
+
+	redacted := url.Values{}
+	for k, v := range form {
+		if secret[k] {
+			redacted.Set(k, redactedValue)
+		} else {
+			redacted[k] = v
+		}
+	}
+	return redacted
+}
+
+// authSidHeader matches the session ID header line of a DMAPI response.
+var authSidHeader = regexp.MustCompile(`(?im)^(auth-sid:[ \t]*)[^\r\n]+`)
+
+// redactBody masks the configured secrets in a response body, and the
+// session ID a DMAPI login returns in its Auth-Sid header line.
+func (c *Client) redactBody(body string) string {
+	return authSidHeader.ReplaceAllString(c.redact(body), "${1}"+redactedValue)
+}
+
+func (c *Client) traceRequest(method, endpoint string, form url.Values) {
+	if c.trace == nil && !c.DebugHTTP {
+		return
+	}
+
+	form = redactForm(form, c.FieldMap)
+
+	if c.DebugHTTP {
+		c.baseLogger().Debug("joker HTTP request",
+			zap.String("method", method),
+			zap.String("endpoint", endpoint),
+			zap.String("form", form.Encode()),
+		)
+	}
+	if c.trace != nil && c.trace.OnRequest != nil {
+		c.trace.OnRequest(method, endpoint, form)
+	}
+}
+
+func (c *Client) traceResponse(method, endpoint string, res *httpResult) {
+	if c.trace == nil && !c.DebugHTTP {
+		return
+	}
+
+	body := c.redactBody(string(res.body))
+
+	if c.DebugHTTP {
+		c.baseLogger().Debug("joker HTTP response",
+			zap.String("method", method),
+			zap.String("endpoint", endpoint),
+			zap.Int("status", res.status),
+			zap.String("body", body),
+		)
+	}
+	if c.trace != nil && c.trace.OnResponse != nil {
+		c.trace.OnResponse(method, endpoint, res.status, body)
+	}
+}
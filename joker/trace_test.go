@@ -0,0 +1,114 @@
+package joker
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/libdns/libdns"
+)
+
+// traced is an exchange seen by the trace hooks.
+type traced struct {
+	method, endpoint string
+	form             url.Values
+	status           int
+	body             string
+}
+
+func TestTrace(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+
+	var (
+		mu        sync.Mutex
+		requests  []traced
+		responses []traced
+	)
+	c := newTestClient(t, f, WithTrace(Trace{
+		OnRequest: func(method, endpoint string, form url.Values) {
+			mu.Lock()
+			defer mu.Unlock()
+			requests = append(requests, traced{method: method, endpoint: endpoint, form: form})
+		},
+		OnResponse: func(method, endpoint string, status int, body string) {
+			mu.Lock()
+			defer mu.Unlock()
+			responses = append(responses, traced{method: method, endpoint: endpoint, status: status, body: body})
+		},
+	}))
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var replace *traced
+	for i, r := range requests {
+		assert.NotContains(t, r.form.Encode(), "secret-token")
+		if r.endpoint == c.Endpoint {
+			replace = &requests[i]
+		}
+	}
+	require.NotNil(t, replace)
+	assert.Equal(t, http.MethodPost, replace.method)
+	assert.Equal(t, "_acme-challenge", replace.form.Get("label"))
+	assert.Equal(t, redactedValue, replace.form.Get("api_token"))
+
+	require.Len(t, responses, len(requests))
+	for _, r := range responses {
+		assert.Equal(t, http.StatusOK, r.status)
+		assert.NotContains(t, r.body, "sid-1", "the DMAPI session ID is redacted")
+	}
+}
+
+func TestTraceNilCallbacks(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	c := newTestClient(t, f, WithTrace(Trace{}))
+
+	assert.NotPanics(t, func() {
+		_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+			libdns.TXT{Name: "_acme-challenge", Text: "token"},
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestDebugHTTP(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	core, logs := observer.New(zap.DebugLevel)
+	c := newTestClient(t, f, func(c *Client) {
+		c.DebugHTTP = true
+		c.logger = zap.New(core)
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	reqs := logs.FilterMessage("joker HTTP request").All()
+	resps := logs.FilterMessage("joker HTTP response").All()
+	require.NotEmpty(t, reqs)
+	assert.Len(t, resps, len(reqs))
+	for _, e := range append(reqs, resps...) {
+		for _, v := range e.ContextMap() {
+			s, _ := v.(string)
+			assert.False(t, strings.Contains(s, "secret-token") || strings.Contains(s, "sid-1"),
+				"%s leaks a secret: %v", e.Message, e.ContextMap())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package joker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// newHTTPClient builds the client used for all Joker requests from the
+// Client's timeout and transport settings.
+func (c *Client) newHTTPClient() (*http.Client, error) {
+	timeout := time.Duration(c.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	if c.MaxIdleConns > 0 {
+		transport.MaxIdleConns = c.MaxIdleConns
+		transport.MaxIdleConnsPerHost = c.MaxIdleConns
+	}
+	if c.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(c.IdleConnTimeout)
+	}
+	transport.DisableKeepAlives = c.DisableKeepAlives
+
+	if c.Proxy != "" {
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", c.Proxy, err)
+		}
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q: must be an absolute URL", c.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if c.CAFile != "" || c.InsecureSkipVerify {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if c.CAFile != "" {
+			pem, err := os.ReadFile(c.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading ca_file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("ca_file %q contains no PEM certificates", c.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if c.InsecureSkipVerify {
+			c.baseLogger().Warn("TLS certificate verification is disabled; do not use in production")
+			tlsConfig.InsecureSkipVerify = true
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}, nil
+}
+
+// setupReplaceClient derives the client for /nic/replace requests when
+// TLSServerName is set: a copy of the shared client whose transport
+// presents that name, leaving DMAPI and IP detection requests, which go
+// to other hosts, on the shared one. A supplied client with a transport
+// other than *http.Transport is used as is.
+func (c *Client) setupReplaceClient() {
+	c.replaceClient = nil
+	if c.TLSServerName == "" {
+		return
+	}
+
+	base := c.httpClient()
+	var transport *http.Transport
+	switch t := base.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		c.baseLogger().Warn("tls_server_name ignored: the HTTP client's transport is not an *http.Transport")
+		return
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if transport.TLSClientConfig != nil {
+		tlsConfig = transport.TLSClientConfig.Clone()
+	}
+	tlsConfig.ServerName = c.TLSServerName
+	transport.TLSClientConfig = tlsConfig
+
+	client := *base
+	client.Transport = transport
+	c.replaceClient = &client
+}
@@ -0,0 +1,474 @@
+package joker
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/libdns/libdns"
+)
+
+func TestHTTPClientTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout Duration
+		want    time.Duration
+	}{
+		{name: "default", want: defaultTimeout},
+		{name: "configured", timeout: Duration(5 * time.Second), want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{Timeout: tt.timeout}
+			client, err := c.newHTTPClient()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, client.Timeout)
+		})
+	}
+}
+
+func TestContextDeadlineBeatsClientTimeout(t *testing.T) {
+	f := newFakeJoker(t)
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}
+	c := newTestClient(t, f)
+
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want error
+	}{
+		{
+			name: "deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			want: context.DeadlineExceeded,
+		},
+		{
+			name: "canceled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			want: context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			start := time.Now()
+			_, err := c.AppendRecords(ctx, "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			require.ErrorIs(t, err, tt.want)
+			assert.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	f := newFakeJoker(t)
+
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+		f.serveHTTP(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+
+	// The endpoint does not resolve, so the request only succeeds if it
+	// goes through the proxy
+	c := newTestClient(t, f, func(c *Client) {
+		c.Endpoint = "http://joker.invalid" + nicReplacePath
+		c.DMAPIEndpoint = "http://joker.invalid" + dmapiPath
+		c.Proxy = proxy.URL
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, proxied, "http://joker.invalid"+nicReplacePath)
+	for _, u := range proxied {
+		assert.True(t, strings.HasPrefix(u, "http://joker.invalid/"), u)
+	}
+}
+
+func TestInvalidProxy(t *testing.T) {
+	for _, proxy := range []string{"proxy.example.com:3128", "http://%zz"} {
+		t.Run(proxy, func(t *testing.T) {
+			c := &Client{Proxy: proxy}
+			_, err := c.newHTTPClient()
+			assert.ErrorContains(t, err, "invalid proxy")
+		})
+	}
+}
+
+func TestCAFile(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(f.serveHTTP))
+	// The untrusted case fails the handshake, which the server would log
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}), 0o600))
+
+	records := []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "token"}}
+
+	t.Run("untrusted", func(t *testing.T) {
+		c := newTestClient(t, f, func(c *Client) {
+			c.Endpoint = srv.URL + nicReplacePath
+			c.DMAPIEndpoint = srv.URL + dmapiPath
+		})
+		_, err := c.AppendRecords(context.Background(), "example.com", records)
+		assert.ErrorContains(t, err, "certificate")
+	})
+
+	t.Run("ca_file", func(t *testing.T) {
+		c := newTestClient(t, f, func(c *Client) {
+			c.Endpoint = srv.URL + nicReplacePath
+			c.DMAPIEndpoint = srv.URL + dmapiPath
+			c.CAFile = caFile
+		})
+		_, err := c.AppendRecords(context.Background(), "example.com", records)
+		assert.NoError(t, err)
+	})
+
+	t.Run("insecure_skip_verify", func(t *testing.T) {
+		core, logs := observer.New(zap.WarnLevel)
+		c := newTestClient(t, f, func(c *Client) {
+			c.Endpoint = srv.URL + nicReplacePath
+			c.DMAPIEndpoint = srv.URL + dmapiPath
+			c.InsecureSkipVerify = true
+			c.logger = zap.New(core)
+		})
+		_, err := c.AppendRecords(context.Background(), "example.com", records)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, logs.FilterMessageSnippet("verification is disabled").Len())
+	})
+}
+
+func TestCAFileInvalid(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("not a certificate"), 0o600))
+
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{name: "missing", file: filepath.Join(dir, "missing.pem"), want: "reading ca_file"},
+		{name: "no certificates", file: empty, want: "contains no PEM certificates"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{CAFile: tt.file}
+			_, err := c.newHTTPClient()
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+func TestPerRequestTimeout(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	f.replace = func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("label") == "slow" {
+			<-r.Context().Done()
+			return
+		}
+		f.serveReplace(w, r.Form)
+	}
+	c := newTestClient(t, f, func(c *Client) {
+		c.PerRequestTimeout = Duration(100 * time.Millisecond)
+		c.ContinueOnError = true
+	})
+
+	results, err := c.AppendRecordsDetailed(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "slow", Text: "token"},
+		libdns.TXT{Name: "fast", Text: "token"},
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.Len(t, results, 2)
+	for _, r := range results {
+		switch r.Record.RR().Name {
+		case "slow":
+			assert.ErrorIs(t, r.Err, context.DeadlineExceeded)
+		case "fast":
+			assert.NoError(t, r.Err)
+		}
+	}
+	assert.Equal(t, []string{`fast TXT 0 "token" 3600`}, f.zone("example.com"))
+}
+
+func TestEndpointFailover(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		status   int
+		closed   bool
+		failover bool
+	}{
+		{name: "bad gateway", status: http.StatusBadGateway, failover: true},
+		{name: "unreachable", closed: true, failover: true},
+		{name: "client error", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJoker(t)
+			f.setZone("example.com")
+
+			var primaryCalls atomic.Int32
+			primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				primaryCalls.Add(1)
+				http.Error(w, "upstream unavailable", tt.status)
+			}))
+			t.Cleanup(primary.Close)
+			first := primary.URL + nicReplacePath
+			if tt.closed {
+				first = closed.URL + nicReplacePath
+			}
+
+			c := newTestClient(t, f, func(c *Client) {
+				c.Endpoint = ""
+				c.Endpoints = []string{first, f.srv.URL + nicReplacePath}
+			})
+
+			_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: "token"},
+			})
+			if !tt.failover {
+				assert.Error(t, err)
+				assert.Empty(t, f.received(nicReplacePath))
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, f.received(nicReplacePath), 1)
+			if !tt.closed {
+				assert.Equal(t, int32(1), primaryCalls.Load())
+			}
+		})
+	}
+}
+
+func TestHTTPClientTransport(t *testing.T) {
+	defaults := http.DefaultTransport.(*http.Transport)
+
+	tests := []struct {
+		name              string
+		p                 Client
+		maxIdleConns      int
+		idleConnTimeout   time.Duration
+		disableKeepAlives bool
+	}{
+		{
+			name:            "defaults",
+			maxIdleConns:    defaults.MaxIdleConns,
+			idleConnTimeout: defaults.IdleConnTimeout,
+		},
+		{
+			name: "configured",
+			p: Client{
+				MaxIdleConns:      4,
+				IdleConnTimeout:   Duration(30 * time.Second),
+				DisableKeepAlives: true,
+			},
+			maxIdleConns:      4,
+			idleConnTimeout:   30 * time.Second,
+			disableKeepAlives: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := tt.p.newHTTPClient()
+			require.NoError(t, err)
+			transport, ok := client.Transport.(*http.Transport)
+			require.True(t, ok)
+
+			assert.Equal(t, tt.maxIdleConns, transport.MaxIdleConns)
+			assert.Equal(t, tt.idleConnTimeout, transport.IdleConnTimeout)
+			assert.Equal(t, tt.disableKeepAlives, transport.DisableKeepAlives)
+			if tt.p.MaxIdleConns > 0 {
+				assert.Equal(t, tt.maxIdleConns, transport.MaxIdleConnsPerHost)
+			}
+		})
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	var mu sync.Mutex
+	var paths []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		http.StripPrefix("/joker", http.HandlerFunc(f.serveHTTP)).ServeHTTP(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+	c := newTestClient(t, f, func(c *Client) {
+		c.Endpoint, c.DMAPIEndpoint = "", ""
+		c.BaseURL = proxy.URL + "/joker/"
+	})
+
+	assert.Equal(t, proxy.URL+"/joker/nic/replace", c.Endpoint)
+	assert.Equal(t, proxy.URL+"/joker/request", c.DMAPIEndpoint)
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"/joker/request/login",
+		"/joker/request/dns-zone-get",
+		"/joker/nic/replace",
+	}, paths)
+}
+
+func TestBaseURLExplicitEndpointWins(t *testing.T) {
+	c := &Client{
+		APIToken: "secret-token",
+		BaseURL:  "https://proxy.example/joker",
+		Endpoint: "https://joker.example/nic/replace",
+	}
+	require.NoError(t, c.setup())
+	require.NoError(t, c.Validate())
+
+	assert.Equal(t, "https://joker.example/nic/replace", c.Endpoint)
+	assert.Equal(t, "https://proxy.example/joker/request", c.DMAPIEndpoint)
+}
+
+func TestBaseURLInvalid(t *testing.T) {
+	c := &Client{APIToken: "secret-token", BaseURL: "proxy.example/joker"}
+	require.NoError(t, c.setup())
+	assert.ErrorContains(t, c.Validate(), `invalid base_url "proxy.example/joker"`)
+}
+
+func TestHostHeader(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	var mu sync.Mutex
+	hosts := make(map[string]string)
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts[r.URL.Path] = r.Host
+		mu.Unlock()
+		f.serveHTTP(w, r)
+	}))
+	t.Cleanup(front.Close)
+	c := newTestClient(t, f, func(c *Client) {
+		c.Endpoint = front.URL + nicReplacePath
+		c.DMAPIEndpoint = front.URL + dmapiPath
+		c.HostHeader = "svc.joker.com"
+	})
+
+	_, err := c.AppendRecords(context.Background(), "example.com", []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "token"},
+	})
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "svc.joker.com", hosts[nicReplacePath])
+	// DMAPI requests go to their endpoint's own host
+	assert.Equal(t, strings.TrimPrefix(front.URL, "http://"), hosts[dmapiPath+"/dns-zone-get"])
+}
+
+func TestTLSServerName(t *testing.T) {
+	f := newFakeJoker(t)
+	f.setZone("example.com")
+	var mu sync.Mutex
+	serverNames := make(map[string]string)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		serverNames[r.URL.Path] = r.TLS.ServerName
+		mu.Unlock()
+		f.serveHTTP(w, r)
+	}))
+	// The mismatched case fails the handshake, which the server would log
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}), 0o600))
+
+	records := []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "token"}}
+
+	t.Run("matching certificate", func(t *testing.T) {
+		// The test server's certificate is also valid for example.com
+		c := newTestClient(t, f, func(c *Client) {
+			c.Endpoint = srv.URL + nicReplacePath
+			c.DMAPIEndpoint = srv.URL + dmapiPath
+			c.CAFile = caFile
+			c.TLSServerName = "example.com"
+		})
+		_, err := c.AppendRecords(context.Background(), "example.com", records)
+		require.NoError(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "example.com", serverNames[nicReplacePath])
+		// DMAPI requests present no name, as they go to an IP address
+		assert.Empty(t, serverNames[dmapiPath+"/dns-zone-get"])
+	})
+
+	t.Run("certificate not valid for the name", func(t *testing.T) {
+		c := newTestClient(t, f, func(c *Client) {
+			c.Endpoint = srv.URL + nicReplacePath
+			c.DMAPIEndpoint = srv.URL + dmapiPath
+			c.CAFile = caFile
+			c.TLSServerName = "svc.joker.com"
+		})
+		_, err := c.AppendRecords(context.Background(), "example.com", records)
+		assert.ErrorContains(t, err, "svc.joker.com")
+	})
+}
@@ -1,54 +1,34 @@
 package caddydnsjoker
 
 import (
-	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"strings"
 	"strconv"
-	"time"
+	"strings"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
-	"go.uber.org/zap"
 
-	"github.com/libdns/libdns"
+	"github.com/samliddicott/caddy-dns-joker/joker"
 )
 
-type rrsetKey struct {
-    zone  string
-    label string
-    rtype string
-}
-
-const defaultEndpoint = "https://svc.joker.com/nic/replace"
-
 func init() {
 	caddy.RegisterModule(Provider{})
 }
 
-// Provider implements libdns interfaces for Joker DNS
+// Provider is the Caddy module for Joker DNS, dns.providers.joker. The
+// record management and all configuration live in joker.Client, which
+// programs outside Caddy can use on their own; Provider adds placeholder
+// expansion, the Caddyfile syntax and metrics registration.
 type Provider struct {
-	// Authentication (exactly one method required)
-	Username string `json:"username,omitempty"`
-	Password string `json:"password,omitempty"`
-	APIToken string `json:"api_token,omitempty"`
+	joker.Client
 
-	// Optional override
-	Endpoint string `json:"endpoint,omitempty"`
-
-	client *http.Client
-	logger *zap.Logger
 	expanded bool
 }
 
 var (
-	_ libdns.RecordAppender = (*Provider)(nil)
-	_ libdns.RecordDeleter  = (*Provider)(nil)
 	_ caddyfile.Unmarshaler = (*Provider)(nil)
 	_ caddy.Provisioner     = (*Provider)(nil)
+	_ caddy.Validator       = (*Provider)(nil)
 )
 
 // CaddyModule returns module info.
@@ -59,52 +39,117 @@ func (Provider) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
-// Provision validates config and sets up client + logger.
+// Provision expands placeholders, then sets up the client with Caddy's
+// logger.
 func (p *Provider) Provision(ctx caddy.Context) error {
 	if !p.expanded {
 		repl := caddy.NewReplacer()
 		p.Username = repl.ReplaceAll(p.Username, "")
 		p.Password = repl.ReplaceAll(p.Password, "")
 		p.APIToken = repl.ReplaceAll(p.APIToken, "")
+		p.APIKey = repl.ReplaceAll(p.APIKey, "")
 		p.Endpoint = repl.ReplaceAll(p.Endpoint, "")
+		for i, ep := range p.Endpoints {
+			p.Endpoints[i] = repl.ReplaceAll(ep, "")
+		}
+		p.DMAPIEndpoint = repl.ReplaceAll(p.DMAPIEndpoint, "")
+		p.BaseURL = repl.ReplaceAll(p.BaseURL, "")
+		p.UserAgent = repl.ReplaceAll(p.UserAgent, "")
+		p.Proxy = repl.ReplaceAll(p.Proxy, "")
+		p.CAFile = repl.ReplaceAll(p.CAFile, "")
+		p.HostHeader = repl.ReplaceAll(p.HostHeader, "")
+		p.TLSServerName = repl.ReplaceAll(p.TLSServerName, "")
+		p.IPDetectURL = repl.ReplaceAll(p.IPDetectURL, "")
+		p.IPv6DetectURL = repl.ReplaceAll(p.IPv6DetectURL, "")
+		p.UsernameFile = repl.ReplaceAll(p.UsernameFile, "")
+		p.PasswordFile = repl.ReplaceAll(p.PasswordFile, "")
+		p.SecretsDir = repl.ReplaceAll(p.SecretsDir, "")
+		for zone, cred := range p.ZoneCredentials {
+			cred.Username = repl.ReplaceAll(cred.Username, "")
+			cred.Password = repl.ReplaceAll(cred.Password, "")
+			cred.APIToken = repl.ReplaceAll(cred.APIToken, "")
+			p.ZoneCredentials[zone] = cred
+		}
 		p.expanded = true
 	}
-	p.client = &http.Client{
-		Timeout: 30 * time.Second,
-	}
-	p.logger = ctx.Logger().Named("dns.joker")
 
-	if p.Endpoint == "" {
-		p.Endpoint = defaultEndpoint
+	if err := joker.RegisterMetrics(ctx.GetMetricsRegistry()); err != nil {
+		return fmt.Errorf("registering metrics: %w", err)
 	}
 
-	hasUserPass := p.Username != "" && p.Password != ""
-	hasToken := p.APIToken != ""
-
-	switch {
-	case hasToken && hasUserPass:
-		return fmt.Errorf("configure either api_token or username/password, not both")
-	case hasToken:
-		// ok
-	case p.Username != "" && p.Password != "":
-		// ok
-	default:
-		return fmt.Errorf("either api_token or username/password must be configured")
-	}
-
-	return nil
+	return p.Setup(joker.WithLogger(ctx.Logger().Named("dns.joker")))
 }
 
-// UnmarshalCaddyfile parses the Caddyfile block:
+// UnmarshalCaddyfile parses the Caddyfile, either the inline form:
+//
+// dns joker <api_token>
 //
-// dns joker {
-//     username ...
-//     password ...
-//     api_token ...
-//     endpoint ...
-// }
+// or the block:
+//
+//	dns joker {
+//	    username ...
+//	    password ...
+//	    username_file ...
+//	    password_file ...
+//	    secrets_dir ...
+//	    zone_credentials <zone> {
+//	        username ...
+//	        password ...
+//	        api_token ...
+//	    }
+//	    api_token ... (or api_key ...)
+//	    mode dyndns|dmapi
+//	    endpoint ...
+//	    endpoints ...
+//	    success_statuses ...
+//	    dmapi_endpoint ...
+//	    base_url ...
+//	    timeout ...
+//	    per_request_timeout ...
+//	    max_idle_conns ...
+//	    idle_conn_timeout ...
+//	    disable_keep_alives
+//	    proxy ...
+//	    ca_file ...
+//	    insecure_skip_verify
+//	    host_header ...
+//	    tls_server_name ...
+//	    max_retries ...
+//	    rate_limit ...
+//	    circuit_breaker <failures> [<window> [<cooldown>]]
+//	    cache_ttl ...
+//	    concurrency ...
+//	    continue_on_error
+//	    rollback_on_error
+//	    default_ttl ...
+//	    force_ttl ...
+//	    min_ttl ...
+//	    max_ttl ...
+//	    wait_for_propagation
+//	    propagation_timeout ...
+//	    resolvers ...
+//	    raw_txt
+//	    skip_unchanged
+//	    report_no_change
+//	    dry_run
+//	    http_method GET|POST
+//	    field_map <field> <name>
+//	    content_type form|json
+//	    debug_http
+//	    send_request_id
+//	    ip_detect_url ...
+//	    ipv6_detect_url ...
+//	    user_agent ...
+//	}
 func (p *Provider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for d.Next() {
+		if d.NextArg() {
+			p.APIToken = d.Val()
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+
 		for d.NextBlock(0) {
 			switch d.Val() {
 			case "username":
@@ -112,270 +157,510 @@ func (p *Provider) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.ArgErr()
 				}
 				p.Username = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
 			case "password":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				p.Password = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "username_file":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.UsernameFile = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "password_file":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.PasswordFile = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-			case "api_token":
+			case "secrets_dir":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.SecretsDir = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "zone_credentials":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				zone := d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+				var cred joker.Credential
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					field := d.Val()
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					switch field {
+					case "username":
+						cred.Username = d.Val()
+					case "password":
+						cred.Password = d.Val()
+					case "api_token", "api_key":
+						cred.APIToken = d.Val()
+					default:
+						return d.Errf("unrecognized zone_credentials directive %q", field)
+					}
+					if d.NextArg() {
+						return d.ArgErr()
+					}
+				}
+
+				if p.ZoneCredentials == nil {
+					p.ZoneCredentials = make(map[string]joker.Credential)
+				}
+				p.ZoneCredentials[zone] = cred
+
+			case "api_token", "api_key":
+				if p.APIToken != "" {
+					return d.Errf("%s already set", d.Val())
+				}
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				p.APIToken = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "mode":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.Mode = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
 			case "endpoint":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				p.Endpoint = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-			default:
-				return d.Errf("unrecognized directive %q", d.Val())
-			}
-		}
-	}
+			case "endpoints":
+				p.Endpoints = append(p.Endpoints, d.RemainingArgs()...)
+				if len(p.Endpoints) == 0 {
+					return d.ArgErr()
+				}
 
-	return nil
-}
+			case "success_statuses":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				for _, arg := range args {
+					code, err := strconv.Atoi(arg)
+					if err != nil || code < 100 || code > 599 {
+						return d.Errf("invalid success status %q", arg)
+					}
+					p.SuccessStatuses = append(p.SuccessStatuses, code)
+				}
 
-// AppendRecords adds DNS records via Joker /nic/replace
-func (p *Provider) AppendRecords(
-	ctx context.Context,
-	zone string,
-	records []libdns.Record,
-) ([]libdns.Record, error) {
-
-	grouped := make(map[rrsetKey][]libdns.Record)
-	z := normalizeZone(zone)
-
-	for _, rec := range records {
-		rr := rec.RR()
-		key := rrsetKey{
-			zone:  z,
-			label: labelRelativeToZone(rr.Name, z),
-			rtype: rr.Type,
-		}
-		grouped[key] = append(grouped[key], rec)
-	}
+			case "dmapi_endpoint":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.DMAPIEndpoint = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	var added []libdns.Record
+			case "base_url":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.BaseURL = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	for key, recs := range grouped {
-		var values []string
-		ttl := minTTL(recs)
+			case "timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid timeout %q: %v", d.Val(), err)
+				}
+				p.Timeout = joker.Duration(dur)
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-		for _, rec := range recs {
-			v := rec.RR().Data
-			if key.rtype == "TXT" {
-				v = normalizeTXT(v)
-			}
-			values = append(values, v)
-		}
+			case "per_request_timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid per_request_timeout %q: %v", d.Val(), err)
+				}
+				p.PerRequestTimeout = joker.Duration(dur)
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-		p.logger.Debug("adding DNS record",
-			zap.String("zone", key.zone),
-			zap.String("label", key.label),
-			zap.String("type", key.rtype),
-		)
-
-		if err := p.replaceRRSet(
-			ctx,
-			key.zone,
-			key.label,
-			key.rtype,
-			values,
-			ttl,
-		); err != nil {
-			return added, err
-		}
+			case "max_idle_conns":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid max_idle_conns %q: %v", d.Val(), err)
+				}
+				p.MaxIdleConns = n
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-		added = append(added, recs...)
-	}
+			case "idle_conn_timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid idle_conn_timeout %q: %v", d.Val(), err)
+				}
+				p.IdleConnTimeout = joker.Duration(dur)
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	return added, nil
-}
+			case "disable_keep_alives":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.DisableKeepAlives = true
 
-// DeleteRecords deletes DNS records via Joker /nic/replace
-func (p *Provider) DeleteRecords(
-	ctx context.Context,
-	zone string,
-	records []libdns.Record,
-) ([]libdns.Record, error) {
-
-	grouped := make(map[rrsetKey][]libdns.Record)
-	z := normalizeZone(zone)
-
-	for _, rec := range records {
-		rr := rec.RR()
-		key := rrsetKey{
-			zone:  z,
-			label: labelRelativeToZone(rr.Name, z),
-			rtype: rr.Type,
-		}
-		grouped[key] = append(grouped[key], rec)
-	}
+			case "proxy":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.Proxy = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	var deleted []libdns.Record
-
-	for key, recs := range grouped {
-		ttl := minTTL(recs)
-
-		p.logger.Debug("deleting DNS record",
-			zap.String("zone", key.zone),
-			zap.String("label", key.label),
-			zap.String("type", key.rtype),
-		)
-
-		// Empty value list = delete entire RRset
-		if err := p.replaceRRSet(
-			ctx,
-			key.zone,
-			key.label,
-			key.rtype,
-			nil,
-			ttl,
-		); err != nil {
-			return deleted, err
-		}
+			case "ca_file":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.CAFile = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-		deleted = append(deleted, recs...)
-	}
+			case "insecure_skip_verify":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.InsecureSkipVerify = true
 
-	return deleted, nil
-}
+			case "host_header":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.HostHeader = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-// replaceRRSet calls Joker's /nic/replace endpoint.
-// An empty value deletes the record.
-func (p *Provider) replaceRRSet(
-	ctx context.Context,
-	zone, label, rtype string,
-	values []string,
-	ttl int,
-) error {
-	zone = normalizeZone(zone)
-	label = strings.TrimSuffix(label, ".")
-
-	// Clamp TTL to Joker's documented range (and your requirement)
-	if ttl < 60 {
-		ttl = 60
-	} else if ttl > 86400 {
-		ttl = 86400
-	}
+			case "tls_server_name":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.TLSServerName = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	form := url.Values{}
-	if p.APIToken != "" {
-		form.Set("api_token", p.APIToken)
-	} else {
-		form.Set("username", p.Username)
-		form.Set("password", p.Password)
-	}
+			case "max_retries":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid max_retries %q: %v", d.Val(), err)
+				}
+				p.MaxRetries = n
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	form.Set("zone", zone)
-	form.Set("label", label)
-	form.Set("type", rtype)
-	form.Set("ttl", strconv.Itoa(ttl))
+			case "rate_limit":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				r, err := strconv.ParseFloat(d.Val(), 64)
+				if err != nil {
+					return d.Errf("invalid rate_limit %q: %v", d.Val(), err)
+				}
+				p.RateLimit = r
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	if len(values) > 0 {
-		form.Set("value", strings.Join(values, ","))
-	} else {
-		form.Set("value", "")
-	}
+			case "circuit_breaker":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(d.Val())
+				if err != nil || n < 1 {
+					return d.Errf("invalid circuit_breaker failures %q", d.Val())
+				}
+				p.BreakerThreshold = n
+				if d.NextArg() {
+					dur, err := caddy.ParseDuration(d.Val())
+					if err != nil {
+						return d.Errf("invalid circuit_breaker window %q: %v", d.Val(), err)
+					}
+					p.BreakerWindow = joker.Duration(dur)
+				}
+				if d.NextArg() {
+					dur, err := caddy.ParseDuration(d.Val())
+					if err != nil {
+						return d.Errf("invalid circuit_breaker cooldown %q: %v", d.Val(), err)
+					}
+					p.BreakerCooldown = joker.Duration(dur)
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	// Safe debug logging (no secrets)
-	p.logFormRedacted(form)
-
-	req, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodPost,
-		p.Endpoint,
-		strings.NewReader(form.Encode()),
-	)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			case "cache_ttl":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid cache_ttl %q: %v", d.Val(), err)
+				}
+				p.CacheTTL = joker.Duration(dur)
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	resp, err := p.client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		p.logger.Error("joker API error",
-			zap.Int("status", resp.StatusCode),
-			zap.ByteString("response", body),
-		)
-		return fmt.Errorf(
-			"joker API error: status=%d response=%s",
-			resp.StatusCode,
-			strings.TrimSpace(string(body)),
-		)
-	}
+			case "concurrency":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid concurrency %q: %v", d.Val(), err)
+				}
+				p.Concurrency = n
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	return nil
-}
+			case "continue_on_error":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.ContinueOnError = true
 
-// normalizeTXT removes surrounding quotes from TXT values if present.
-func normalizeTXT(v string) string {
-	if len(v) >= 2 && strings.HasPrefix(v, `"`) && strings.HasSuffix(v, `"`) {
-		return strings.Trim(v, `"`)
-	}
-	return v
-}
+			case "rollback_on_error":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.RollbackOnError = true
 
-// Select the minimum TTL of all records, as with joker single update they
-// must share one TTL, but ensure it is within the joker permitted range
-func minTTL(records []libdns.Record) int {
-	if len(records) == 0 {
-		return 60
-	}
+			case "default_ttl":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid default_ttl %q: %v", d.Val(), err)
+				}
+				p.DefaultTTL = joker.Duration(dur)
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	min := int(records[0].RR().TTL.Seconds())
-	for _, r := range records[1:] {
-		if t := int(r.RR().TTL.Seconds()); t < min {
-			min = t
-		}
-	}
+			case "force_ttl":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid force_ttl %q: %v", d.Val(), err)
+				}
+				p.ForceTTL = joker.Duration(dur)
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-	if min < 60 {
-		min = 60
-	} else if min > 86400 {
-		min = 86400
-	}
-	return min
-}
+			case "min_ttl", "max_ttl":
+				name := d.Val()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid %s %q: %v", name, d.Val(), err)
+				}
+				if name == "min_ttl" {
+					p.MinTTL = joker.Duration(dur)
+				} else {
+					p.MaxTTL = joker.Duration(dur)
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-func normalizeZone(z string) string {
-    return strings.TrimSuffix(z, ".")
-}
+			case "wait_for_propagation":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.WaitForPropagation = true
+
+			case "propagation_timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid propagation_timeout %q: %v", d.Val(), err)
+				}
+				p.PropagationTimeout = joker.Duration(dur)
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-func labelRelativeToZone(name, zone string) string {
-	name = strings.TrimSuffix(name, ".")
-	zone = strings.TrimSuffix(zone, ".")
+			case "resolvers":
+				p.Resolvers = append(p.Resolvers, d.RemainingArgs()...)
+				if len(p.Resolvers) == 0 {
+					return d.ArgErr()
+				}
 
-	// If already relative (no zone suffix), keep it as-is.
-	// If it ends with ".<zone>", strip that suffix.
-	suffix := "." + zone
-	if strings.HasSuffix(name, suffix) {
-		name = strings.TrimSuffix(name, suffix)
-	}
-	return strings.TrimSuffix(name, ".")
-}
+			case "raw_txt":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.RawTXT = true
+
+			case "skip_unchanged":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.SkipUnchanged = true
+
+			case "report_no_change":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.ReportNoChange = true
+
+			case "dry_run":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.DryRun = true
+
+			case "http_method":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.HTTPMethod = strings.ToUpper(d.Val())
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "field_map":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				from := d.Val()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				if p.FieldMap == nil {
+					p.FieldMap = make(map[string]string)
+				}
+				p.FieldMap[from] = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 
-func (p *Provider) logFormRedacted(form url.Values) {
-	redacted := url.Values{}
-	for k, v := range form {
-		switch k {
-		case "password", "api_token":
-			redacted.Set(k, "<redacted>")
-		default:
-			redacted[k] = v
+			case "content_type":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.ContentType = strings.ToLower(d.Val())
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "debug_http":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.DebugHTTP = true
+
+			case "send_request_id":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				p.SendRequestID = true
+
+			case "ip_detect_url":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.IPDetectURL = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "ipv6_detect_url":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.IPv6DetectURL = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			case "user_agent":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.UserAgent = d.Val()
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+
+			default:
+				return d.Errf("unrecognized directive %q", d.Val())
+			}
 		}
 	}
-	p.logger.Debug("joker request form", zap.Any("form", redacted))
-}
 
+	return nil
+}
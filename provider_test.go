@@ -0,0 +1,286 @@
+package caddydnsjoker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/samliddicott/caddy-dns-joker/joker"
+)
+
+func TestCaddyModule(t *testing.T) {
+	info := Provider{}.CaddyModule()
+	assert.Equal(t, caddy.ModuleID("dns.providers.joker"), info.ID)
+	assert.IsType(t, new(Provider), info.New())
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	var p Provider
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"api_token": "my-token",
+		"mode": "dmapi",
+		"timeout": "45s",
+		"zone_credentials": {"example.org": {"username": "bob", "password": "hunter2"}}
+	}`), &p))
+	assert.Equal(t, joker.Client{
+		APIToken: "my-token",
+		Mode:     "dmapi",
+		Timeout:  joker.Duration(45 * time.Second),
+		ZoneCredentials: map[string]joker.Credential{
+			"example.org": {Username: "bob", Password: "hunter2"},
+		},
+	}, p.Client)
+}
+
+func TestUnmarshalCaddyfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    joker.Client
+		wantErr string
+	}{
+		{
+			name: "block",
+			input: `joker {
+				username alice
+				password s3cret
+				endpoint https://joker.example/nic/replace
+			}`,
+			want: joker.Client{
+				Username: "alice",
+				Password: "s3cret",
+				Endpoint: "https://joker.example/nic/replace",
+			},
+		},
+		{
+			name: "failover endpoints",
+			input: `joker my-token {
+				endpoint https://joker.example/nic/replace
+				endpoints https://a.joker.example/nic/replace https://b.joker.example/nic/replace
+			}`,
+			want: joker.Client{
+				APIToken: "my-token",
+				Endpoint: "https://joker.example/nic/replace",
+				Endpoints: []string{
+					"https://a.joker.example/nic/replace",
+					"https://b.joker.example/nic/replace",
+				},
+			},
+		},
+		{
+			name: "zone credentials",
+			input: `joker my-token {
+				zone_credentials example.org {
+					username bob
+					password hunter2
+				}
+			}`,
+			want: joker.Client{
+				APIToken: "my-token",
+				ZoneCredentials: map[string]joker.Credential{
+					"example.org": {Username: "bob", Password: "hunter2"},
+				},
+			},
+		},
+		{
+			name: "success statuses",
+			input: `joker my-token {
+				success_statuses 200 204
+			}`,
+			want: joker.Client{
+				APIToken:        "my-token",
+				SuccessStatuses: []int{200, 204},
+			},
+		},
+		{
+			name: "invalid success status",
+			input: `joker my-token {
+				success_statuses 200 ok
+			}`,
+			wantErr: `invalid success status "ok"`,
+		},
+		{
+			name: "field map",
+			input: `joker my-token {
+				field_map value myip
+				field_map label hostname
+			}`,
+			want: joker.Client{
+				APIToken: "my-token",
+				FieldMap: map[string]string{"value": "myip", "label": "hostname"},
+			},
+		},
+		{
+			name: "json content type",
+			input: `joker my-token {
+				content_type json
+			}`,
+			want: joker.Client{
+				APIToken:    "my-token",
+				ContentType: "json",
+			},
+		},
+		{
+			name: "circuit breaker",
+			input: `joker my-token {
+				circuit_breaker 5 10m 30s
+			}`,
+			want: joker.Client{
+				APIToken:         "my-token",
+				BreakerThreshold: 5,
+				BreakerWindow:    joker.Duration(10 * time.Minute),
+				BreakerCooldown:  joker.Duration(30 * time.Second),
+			},
+		},
+		{
+			name: "invalid circuit breaker",
+			input: `joker my-token {
+				circuit_breaker 0
+			}`,
+			wantErr: `invalid circuit_breaker failures "0"`,
+		},
+		{
+			name: "base url",
+			input: `joker my-token {
+				base_url https://proxy.example/joker
+			}`,
+			want: joker.Client{
+				APIToken: "my-token",
+				BaseURL:  "https://proxy.example/joker",
+			},
+		},
+		{
+			name: "host header and tls server name",
+			input: `joker my-token {
+				endpoint https://10.0.0.5/nic/replace
+				host_header svc.joker.com
+				tls_server_name svc.joker.com
+			}`,
+			want: joker.Client{
+				APIToken:      "my-token",
+				Endpoint:      "https://10.0.0.5/nic/replace",
+				HostHeader:    "svc.joker.com",
+				TLSServerName: "svc.joker.com",
+			},
+		},
+		{
+			name: "cache ttl",
+			input: `joker my-token {
+				cache_ttl 30s
+			}`,
+			want: joker.Client{
+				APIToken: "my-token",
+				CacheTTL: joker.Duration(30 * time.Second),
+			},
+		},
+		{
+			name:  "inline",
+			input: "joker my-token",
+			want:  joker.Client{APIToken: "my-token"},
+		},
+		{
+			name:    "inline with extra argument",
+			input:   "joker my-token extra",
+			wantErr: "wrong argument count",
+		},
+		{
+			name: "unknown subdirective",
+			input: `joker {
+				colour blue
+			}`,
+			wantErr: `unrecognized directive "colour"`,
+		},
+		{
+			name: "missing value",
+			input: `joker {
+				username
+			}`,
+			wantErr: "wrong argument count",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Provider
+			err := p.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tt.input))
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, p.Client)
+		})
+	}
+}
+
+func TestProvisionExpandsPlaceholders(t *testing.T) {
+	t.Setenv("JOKER_TEST_TOKEN", "token-from-env")
+
+	var mu sync.Mutex
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		keys = append(keys, r.URL.Path+" "+r.Form.Get("api-key"))
+		mu.Unlock()
+		fmt.Fprint(w, "Status-Code: 0\nStatus-Text: OK\nAuth-Sid: sid-1\n\n")
+	}))
+	t.Cleanup(srv.Close)
+
+	p := &Provider{Client: joker.Client{
+		APIToken:      "{env.JOKER_TEST_TOKEN}",
+		DMAPIEndpoint: srv.URL + "/request",
+	}}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	require.NoError(t, p.Provision(ctx))
+	require.NoError(t, p.Validate())
+	require.NoError(t, p.VerifyCredentials(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"/request/login token-from-env"}, keys)
+}
+
+func TestValidateCatchesUnsetPlaceholder(t *testing.T) {
+	p := &Provider{Client: joker.Client{APIToken: "{env.JOKER_TEST_UNSET}"}}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	require.NoError(t, p.Provision(ctx))
+	assert.Error(t, p.Validate())
+}
+
+func TestUnmarshalCaddyfileTimeout(t *testing.T) {
+	var p Provider
+	require.NoError(t, p.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`joker {
+		timeout 45s
+	}`)))
+	assert.Equal(t, joker.Duration(45*time.Second), p.Timeout)
+}
+
+func TestUnmarshalCaddyfileConnections(t *testing.T) {
+	var p Provider
+	require.NoError(t, p.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`joker {
+		max_idle_conns 4
+		idle_conn_timeout 30s
+		disable_keep_alives
+	}`)))
+	assert.Equal(t, 4, p.MaxIdleConns)
+	assert.Equal(t, joker.Duration(30*time.Second), p.IdleConnTimeout)
+	assert.True(t, p.DisableKeepAlives)
+}